@@ -1,65 +1,264 @@
 package api
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
+	"fizzbuzz-server/api/docshandler"
 	"fizzbuzz-server/api/fizzbuzzhandler"
+	"fizzbuzz-server/api/healthhandler"
+	"fizzbuzz-server/api/metrics"
 	"fizzbuzz-server/api/mostfreqreqhandler"
+	"fizzbuzz-server/api/ratelimit"
+	"fizzbuzz-server/api/topreqhandler"
 	"fizzbuzz-server/config"
+	"fizzbuzz-server/internal/lrucache"
+	"fizzbuzz-server/internal/requestid"
 	"fizzbuzz-server/internal/stats"
 
-	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 )
 
 // Api represents the API of the fizzbuzz server
 type Api struct {
 	*http.Server
-	counter stats.FizzbuzzCounter
+	counter   stats.Counter
+	metrics   *metrics.Metrics
+	readiness *healthhandler.Readiness
+	// drainDelay is how long the server keeps serving once the readiness probe fails, before shutting down
+	drainDelay time.Duration
+	// shutdownHooks are run in order once the server is shut down
+	shutdownHooks []func() error
+	// middlewares wrap the handler of each route, the first one being the outermost
+	middlewares []Middleware
+	// prewarm fills the response cache in the background once the server is started, nil if there is nothing to fill
+	prewarm func()
 }
 
-// ProcessFunc is a template func that can be wrapped with 'handlerWithLogs'
-type ProcessFunc func(*http.Request, stats.FizzbuzzCounter) (
+// ProcessFunc is a template func that can be turned into a handler with 'handler'
+type ProcessFunc func(*http.Request, stats.Counter) (
 	statusCode int,
 	headers map[string][]string,
 	body []byte,
 	err error,
 )
 
-// Init initialize API server with a new counter
-func Init(conf config.Conf) *Api {
+// StreamFunc is a template func that can be turned into a handler with 'streamHandler'
+// the body is written by the returned func, allowing large responses to be sent without being kept in memory
+type StreamFunc func(*http.Request, stats.Counter) (
+	statusCode int,
+	headers map[string][]string,
+	writeBody func(io.Writer) error,
+	err error,
+)
+
+// streamChunkSize is the amount of body buffered before being sent as a chunk in streamed responses
+const streamChunkSize = 32 * 1024
+
+// streamWriteMargin is the time left to send the end of a streamed response once its processing deadline passed
+const streamWriteMargin = 5 * time.Second
+
+// Init initialize API server with this counter
+// each API has its own router, metrics and readiness, so several APIs can coexist in the same process
+func Init(conf config.Conf, counter stats.Counter) *Api {
+	m := metrics.New(counter)
 	api := &Api{
-		Server:  &http.Server{Addr: fmt.Sprintf(":%d", conf.Port)},
-		counter: stats.NewFizzbuzzCounter(),
+		counter:    m.Counter(counter),
+		metrics:    m,
+		readiness:  &healthhandler.Readiness{},
+		drainDelay: conf.DrainDelay,
+	}
+	if conf.MiddlewareRequestID {
+		api.middlewares = append(api.middlewares, requestIDMiddleware)
+	}
+	if conf.MiddlewareLogs {
+		api.middlewares = append(api.middlewares, logsMiddleware)
+	}
+	if conf.MiddlewareMetrics {
+		api.middlewares = append(api.middlewares, metricsMiddleware(api.metrics))
+	}
+	// inside the logs, so they record the size of the responses sent
+	if conf.MiddlewareCompression {
+		api.middlewares = append(api.middlewares, compressionMiddleware(conf.CompressionMinSize, conf.CompressionLevel))
+	}
+	var limiter *ratelimit.Limiter
+	if conf.RateLimitRate > 0 {
+		limiter = ratelimit.NewLimiter(conf.RateLimitRate, conf.RateLimitBurst)
+		api.counter = limiter.Counter(api.counter, conf.RateLimitElementsPerToken)
+	}
+	if len(conf.ParsedAPIKeys) > 0 {
+		// the clients failing to authenticate are limited by IP, as they have no key
+		if limiter != nil {
+			api.middlewares = append(api.middlewares, authFailureLimitMiddleware(limiter, conf.TrustedProxyNets,
+				"/healthz", "/readyz", "/metrics"))
+		}
+		// the probes and the metrics are used by the infrastructure, which has no key, and the documentation is public
+		api.middlewares = append(api.middlewares, authMiddleware(conf.ParsedAPIKeys,
+			"/healthz", "/readyz", "/metrics",
+			"/openapi.json", "/docs", "/docs/swagger-ui.css", "/docs/swagger-ui-bundle.js"))
+	}
+	if limiter != nil {
+		// the probes and the metrics are polled by the infrastructure, often sharing the IP of a load balancer,
+		// a limited probe would take the server out of rotation
+		api.middlewares = append(api.middlewares, rateLimitMiddleware(limiter, conf.TrustedProxyNets,
+			"/healthz", "/readyz", "/metrics"))
+	}
+	if conf.RequestTimeout > 0 || conf.StreamTimeout > 0 {
+		api.middlewares = append(api.middlewares, timeoutMiddleware(conf.RequestTimeout, conf.StreamTimeout))
+	}
+	// innermost, so the internal errors answered are logged and recorded as any response
+	if conf.MiddlewareRecovery {
+		api.middlewares = append(api.middlewares, recoveryMiddleware(api.metrics))
 	}
-	http.HandleFunc("/fizzbuzz", api.handlerWithLogs(fizzbuzzhandler.ProcessFizzbuzz))
-	http.HandleFunc("/mostfreqreq", api.handlerWithLogs(mostfreqreqhandler.ProcessMostFrequentReq))
 
+	router := newRouter(api.wrapProcess)
+	fizzbuzzHandler := fizzbuzzhandler.Handler{
+		MaxLimit:       conf.MaxLimit,
+		MaxStreamLimit: conf.MaxStreamLimit,
+		MaxBodyBytes:   conf.MaxBodyBytes,
+		CacheMaxAge:    conf.CacheMaxAge,
+		PrivateCache:   len(conf.ParsedAPIKeys) > 0,
+	}
+	if conf.ResponseCacheBytes > 0 {
+		fizzbuzzHandler.Cache = lrucache.New(conf.ResponseCacheBytes)
+		api.metrics.ObserveCache(fizzbuzzHandler.Cache)
+		if conf.ResponseCachePrewarm > 0 {
+			// the pre-warming is stopped by the shutdown, so it does not outlive the server
+			ctx, cancel := context.WithCancel(context.Background())
+			api.prewarm = func() {
+				prewarmCache(ctx, fizzbuzzHandler, counter, conf.ResponseCachePrewarm, conf.ResponseCachePrewarmTimeout)
+			}
+			api.OnShutdown(func() error {
+				cancel()
+				return nil
+			})
+		}
+	}
+	router.handle("/fizzbuzz", api.wrap(streamable(
+		api.handler(fizzbuzzHandler.ProcessFizzbuzz),
+		api.streamHandler(fizzbuzzHandler.StreamFizzbuzz),
+	)), "GET", "POST")
+	router.handle("/mostfreqreq", api.wrapProcess(mostfreqreqhandler.ProcessMostFrequentReq), "GET")
+	router.handle("/topreq", api.wrapProcess(topreqhandler.ProcessTopReq), "GET")
+	router.handle("/v2/fizzbuzz", api.wrap(streamable(
+		api.handler(fizzbuzzHandler.ProcessFizzbuzzV2),
+		api.streamHandler(fizzbuzzHandler.StreamFizzbuzzV2),
+	)), "GET", "POST")
+	router.handle("/v2/mostfreqreq", api.wrapProcess(mostfreqreqhandler.ProcessMostFrequentRules), "GET")
+	// the metrics not recorded by the middleware, like those of the counter and the runtime, are served anyway
+	router.handle("/metrics", api.wrap(api.metrics.Handler()), "GET")
+	router.handle("/healthz", api.wrapProcess(healthhandler.ProcessHealthz), "GET")
+	router.handle("/readyz", api.wrapProcess(api.readiness.ProcessReadyz), "GET")
+	// the routes are described in the OpenAPI document, which must be updated along with them
+	router.handle("/openapi.json", api.wrapProcess(docshandler.ProcessOpenAPI), "GET")
+	router.handle("/docs", api.wrapProcess(docshandler.ProcessDocs), "GET")
+	router.handle("/docs/swagger-ui.css", api.wrapProcess(docshandler.ProcessSwaggerUICSS), "GET")
+	router.handle("/docs/swagger-ui-bundle.js", api.wrapProcess(docshandler.ProcessSwaggerUIBundle), "GET")
+
+	api.Server = &http.Server{
+		Addr:              fmt.Sprintf(":%d", conf.Port),
+		Handler:           router,
+		ReadHeaderTimeout: conf.ReadHeaderTimeout,
+		ReadTimeout:       conf.ReadTimeout,
+		WriteTimeout:      conf.WriteTimeout,
+		IdleTimeout:       conf.IdleTimeout,
+	}
+	api.OnShutdown(func() error {
+		if errFlush := api.counter.Flush(); errFlush != nil {
+			return fmt.Errorf("flushing counter: %w", errFlush)
+		}
+		return nil
+	})
 	return api
 }
 
-// Run starts the server
+// prewarmCache fills the response cache of the handler with the outputs of the k most frequent requests of the counter
+// the server serves without them meanwhile, so a failure is only reported, a timeout <= 0 meaning no deadline
+func prewarmCache(ctx context.Context, handler fizzbuzzhandler.Handler, counter stats.Counter, k int, timeout time.Duration) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cached, errPrewarm := handler.Prewarm(ctx, counter, k)
+	if errPrewarm != nil {
+		log.Warn().Err(errPrewarm).Int("cached", cached).Msg("error while pre-warming response cache")
+		return
+	}
+	log.Info().Int("cached", cached).Msg("response cache pre-warmed")
+}
+
+// OnShutdown registers a hook run by Shutdown once the server is shut down, after the hooks registered before
+// the first hook flushes the counter, so the hooks registered later can release its resources
+func (a *Api) OnShutdown(hook func() error) {
+	a.shutdownHooks = append(a.shutdownHooks, hook)
+}
+
+// Run starts the server, then pre-warms the response cache in the background
 func (a *Api) Run() error {
 	log.Info().Str("addr", a.Addr).Msg("starting server")
+	if a.prewarm != nil {
+		go a.prewarm()
+	}
 	return a.ListenAndServe()
 }
 
-func (a *Api) handlerWithLogs(f ProcessFunc) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		reqID := uuid.New()
-		log.Info().
-			Str("address", r.RemoteAddr).
-			Str("requestID", reqID.String()).
-			Str("route", r.URL.Path).
-			Msg("received request")
+// Shutdown makes the readiness probe fail, keeps serving during the drain delay so the load balancers
+// stop sending traffic, then gracefully shuts down the server and runs the shutdown hooks
+// the drain is cut short if the context is done before its end, the hooks are run even if the server
+// did not shut down in time so the counts are persisted anyway
+func (a *Api) Shutdown(ctx context.Context) error {
+	a.readiness.Drain()
+	log.Info().Dur("drainDelay", a.drainDelay).Msg("draining server")
+	drainTimer := time.NewTimer(a.drainDelay)
+	select {
+	case <-drainTimer.C:
+	case <-ctx.Done():
+		drainTimer.Stop()
+	}
+
+	errShutdown := a.Server.Shutdown(ctx)
+	var errHooks error
+	for _, hook := range a.shutdownHooks {
+		if errHook := hook(); errHook != nil {
+			// keep running the other hooks, only the first error is returned
+			log.Error().Err(errHook).Msg("error while running shutdown hook")
+			if errHooks == nil {
+				errHooks = errHook
+			}
+		}
+	}
+	if errHooks != nil {
+		return fmt.Errorf("running shutdown hooks: %w", errHooks)
+	}
+	if errShutdown != nil {
+		return fmt.Errorf("shutting down server: %w", errShutdown)
+	}
+	return nil
+}
+
+// wrap wraps the handler with the middlewares of the API
+func (a *Api) wrap(handler http.Handler) http.Handler {
+	return chain(handler, a.middlewares...)
+}
 
+// wrapProcess turns the process into a handler wrapped with the middlewares of the API
+func (a *Api) wrapProcess(f ProcessFunc) http.Handler {
+	return a.wrap(a.handler(f))
+}
+
+// handler turns the process into a handler writing its response
+func (a *Api) handler(f ProcessFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		code, headersMap, body, errProcess := f(r, a.counter)
 		if errProcess != nil {
-			log.Warn().
-				Err(errProcess).
-				Str("requestID", reqID.String()).
-				Msg("error while processing request")
+			logger := requestid.Logger(r.Context())
+			logger.Warn().Err(errProcess).Msg("error while processing request")
 		}
 		for headerKey, headers := range headersMap {
 			for _, header := range headers {
@@ -68,9 +267,73 @@ func (a *Api) handlerWithLogs(f ProcessFunc) func(http.ResponseWriter, *http.Req
 		}
 		w.WriteHeader(code)
 		w.Write(body)
-		log.Info().
-			Str("body", string(body)).
-			Str("requestID", reqID.String()).
-			Msg("sending response")
 	}
 }
+
+// streamHandler turns the process into a handler streaming its response
+func (a *Api) streamHandler(f StreamFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, headersMap, writeBody, errProcess := f(r, a.counter)
+		logger := requestid.Logger(r.Context())
+		if errProcess != nil {
+			logger.Warn().Err(errProcess).Msg("error while processing request")
+		}
+		for headerKey, headers := range headersMap {
+			for _, header := range headers {
+				w.Header().Add(headerKey, header)
+			}
+		}
+		// the write timeout of the server is meant for the buffered responses, a streamed one is sent until
+		// its own deadline
+		writeDeadline := time.Time{}
+		if deadline, ok := r.Context().Deadline(); ok {
+			writeDeadline = deadline.Add(streamWriteMargin)
+		}
+		if errDeadline := http.NewResponseController(w).SetWriteDeadline(writeDeadline); errDeadline != nil {
+			logger.Debug().Err(errDeadline).Msg("write deadline of the stream not set")
+		}
+		w.WriteHeader(code)
+
+		// without content length, each flushed chunk is sent using chunked transfer encoding
+		bufWriter := bufio.NewWriterSize(flushWriter{w}, streamChunkSize)
+		errWrite := writeBody(bufWriter)
+		if errWrite == nil {
+			errWrite = bufWriter.Flush()
+		}
+		if errWrite != nil {
+			logger.Warn().Err(errWrite).Msg("error while streaming response")
+			// the response is not terminated, so the client knows it is incomplete instead of seeing a shorter output
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// streamable dispatches the requests with the 'stream' query parameter set to true to the stream handler
+func streamable(handler, streamHandler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isStream(r) {
+			streamHandler(w, r)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// isStream tells if the 'stream' query parameter of the request is set to true
+func isStream(r *http.Request) bool {
+	stream, _ := strconv.ParseBool(r.URL.Query().Get("stream"))
+	return stream
+}
+
+// flushWriter sends what is written to the client right away
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, errWrite := fw.w.Write(p)
+	if flusher, ok := fw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, errWrite
+}
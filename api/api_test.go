@@ -2,9 +2,8 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fizzbuzz-server/api/fizzbuzzhandler"
-	"fizzbuzz-server/api/mostfreqreqhandler"
 	"fizzbuzz-server/config"
 	"fizzbuzz-server/internal/fizzbuzz"
 	"fizzbuzz-server/internal/stats"
@@ -12,19 +11,25 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // integration tests
 func Test_Fizzbuzz(t *testing.T) {
+	t.Parallel()
 	assertions := assert.New(t)
 
 	params1 := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
 	params2 := fizzbuzz.Params{Int1: 2, Int2: 7, Limit: 16, Str1: "fazz", Str2: "bozz"}
 
-	api := Init(config.Conf{})
+	api := Init(config.Conf{}, stats.NewFizzbuzzCounter())
 
 	// request 1: mostfreqreq without any previous requests
 	gotCode, gotMostFreqReq, gotErr := getMostFreqReq(api)
@@ -75,6 +80,432 @@ func Test_Fizzbuzz(t *testing.T) {
 	assertions.NoError(gotErr, "req 6 - error")
 	assertions.Equal(http.StatusOK, gotCode, "req 6 - wrong code")
 	assertions.Equal(stats.MostFrequentReq{Count: 2, Params: []fizzbuzz.Params{params1}}, gotMostFreqReq, "req 6 - wrong body")
+
+	// request 7: topreq - get both params sets ranked
+	gotCode, gotTopReq, gotErr := getTopReq(api, 5)
+	assertions.NoError(gotErr, "req 7 - error")
+	assertions.Equal(http.StatusOK, gotCode, "req 7 - wrong code")
+	assertions.Equal([]stats.ReqCount{{Count: 2, Params: params1}, {Count: 1, Params: params2}}, gotTopReq, "req 7 - wrong body")
+}
+
+// stress test, should be run with the race detector
+func Test_ParallelRequests(t *testing.T) {
+	assertions := assert.New(t)
+
+	// avoid flooding the output with request logs
+	lvl := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	defer zerolog.SetGlobalLevel(lvl)
+
+	const nbRequests = 2000
+	params := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+
+	api := Init(config.Conf{}, stats.NewFizzbuzzCounter())
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 2*nbRequests)
+	for i := 0; i < nbRequests; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			code, _, err := getFizzbuzz(api, params)
+			if err == nil && code != http.StatusOK {
+				err = fmt.Errorf("fizzbuzz: unexpected code %d", code)
+			}
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			code, _, err := getMostFreqReq(api)
+			if err == nil && code != http.StatusOK {
+				err = fmt.Errorf("mostfreqreq: unexpected code %d", code)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assertions.NoError(err)
+	}
+	gotCode, gotMostFreqReq, gotErr := getMostFreqReq(api)
+	assertions.NoError(gotErr)
+	assertions.Equal(http.StatusOK, gotCode)
+	assertions.Equal(stats.MostFrequentReq{Count: nbRequests, Params: []fizzbuzz.Params{params}}, gotMostFreqReq)
+}
+
+func Test_FizzbuzzStream(t *testing.T) {
+	assertions := assert.New(t)
+
+	lvl := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	defer zerolog.SetGlobalLevel(lvl)
+
+	api := Init(config.Conf{}, stats.NewFizzbuzzCounter())
+	server := httptest.NewServer(api.Handler)
+	defer server.Close()
+
+	// streamed and buffered responses are identical
+	reqBody := `{"int1":3,"int2":5,"limit":1000,"str1":"fizz","str2":"buzz"}`
+	var bodies [2][]byte
+	for i, query := range []string{"", "?stream=true"} {
+		req, errReq := http.NewRequest("GET", server.URL+"/fizzbuzz"+query, bytes.NewReader([]byte(reqBody)))
+		assertions.NoError(errReq)
+		resp, errResp := http.DefaultClient.Do(req)
+		assertions.NoError(errResp)
+		assertions.Equal(http.StatusOK, resp.StatusCode)
+		bodies[i], errResp = ioutil.ReadAll(resp.Body)
+		assertions.NoError(errResp)
+		resp.Body.Close()
+	}
+	assertions.Equal(bodies[0], bodies[1], "streamed body different")
+
+	// large limit is sent in chunks
+	reqBody = `{"int1":3,"int2":5,"limit":1000000,"str1":"fizz","str2":"buzz"}`
+	req, errReq := http.NewRequest("GET", server.URL+"/fizzbuzz?stream=true", bytes.NewReader([]byte(reqBody)))
+	assertions.NoError(errReq)
+	resp, errResp := http.DefaultClient.Do(req)
+	assertions.NoError(errResp)
+	defer resp.Body.Close()
+	assertions.Equal(http.StatusOK, resp.StatusCode)
+	assertions.Equal([]string{"chunked"}, resp.TransferEncoding)
+
+	decoder := json.NewDecoder(resp.Body)
+	_, errToken := decoder.Token() // opening bracket
+	assertions.NoError(errToken)
+	nbElems := 0
+	var last string
+	for decoder.More() {
+		assertions.NoError(decoder.Decode(&last))
+		nbElems++
+	}
+	assertions.Equal(1000000, nbElems)
+	assertions.Equal("buzz", last)
+}
+
+func Test_FizzbuzzParamsSources(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	params := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	counter := stats.NewFizzbuzzCounter()
+	api := Init(config.Conf{}, counter)
+
+	reqs := map[string]*http.Request{
+		"GET JSON":  httptest.NewRequest("GET", "/fizzbuzz", strings.NewReader(`{"int1":3,"int2":5,"limit":16,"str1":"fizz","str2":"buzz"}`)),
+		"GET query": httptest.NewRequest("GET", "/fizzbuzz?int1=3&int2=5&limit=16&str1=fizz&str2=buzz", nil),
+		"POST JSON": httptest.NewRequest("POST", "/fizzbuzz", strings.NewReader(`{"int1":3,"int2":5,"limit":16,"str1":"fizz","str2":"buzz"}`)),
+		"POST form": httptest.NewRequest("POST", "/fizzbuzz", strings.NewReader(`int1=3&int2=5&limit=16&str1=fizz&str2=buzz`)),
+	}
+	reqs["POST form"].Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	for name, req := range reqs {
+		rr := httptest.NewRecorder()
+		api.Handler.ServeHTTP(rr, req)
+		assertions.Equal(http.StatusOK, rr.Code, "%s - wrong code", name)
+		assertions.Equal(`["1","2","fizz","4","buzz","fizz","7","8","fizz","buzz","11","fizz","13","14","fizzbuzz","16"]`, rr.Body.String(), "%s - wrong body", name)
+	}
+
+	// all the requests are counted as the same params
+	gotCount, gotErr := counter.Get(context.Background(), params)
+	assertions.NoError(gotErr)
+	assertions.Equal(len(reqs), gotCount)
+}
+
+func Test_FizzbuzzV2(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	ruleSet := fizzbuzz.RuleSet{
+		Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}, {Divisor: 5, Word: "buzz"}, {Divisor: 7, Word: "bazz"}},
+		Limit: 21,
+	}
+	api := Init(config.Conf{}, stats.NewFizzbuzzCounter())
+
+	for i := 0; i < 2; i++ {
+		body, errJson := json.Marshal(ruleSet)
+		assertions.NoError(errJson)
+		rr := httptest.NewRecorder()
+		api.Handler.ServeHTTP(rr, httptest.NewRequest("POST", "/v2/fizzbuzz", bytes.NewReader(body)))
+		assertions.Equal(http.StatusOK, rr.Code)
+		assertions.Equal(`["1","2","fizz","4","buzz","fizz","bazz","8","fizz","buzz","11",`+
+			`"fizz","13","bazz","fizzbuzz","16","17","fizz","19","buzz","fizzbazz"]`, rr.Body.String())
+	}
+
+	// the classic fizzbuzz is counted apart
+	gotCode, _, gotErr := getFizzbuzz(api, fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"})
+	assertions.NoError(gotErr)
+	assertions.Equal(http.StatusOK, gotCode)
+
+	rr := httptest.NewRecorder()
+	api.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/v2/mostfreqreq", nil))
+	assertions.Equal(http.StatusOK, rr.Code)
+	var gotMostFreqRules stats.MostFrequentRules
+	assertions.NoError(json.Unmarshal(rr.Body.Bytes(), &gotMostFreqRules))
+	assertions.Equal(stats.MostFrequentRules{Count: 2, RuleSets: []fizzbuzz.RuleSet{ruleSet}}, gotMostFreqRules)
+}
+
+func Test_FizzbuzzV2Pagination(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	ruleSet := fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}, {Divisor: 5, Word: "buzz"}}, Limit: 23}
+	want, errExec := fizzbuzz.ExecRules(context.Background(), ruleSet)
+	assertions.NoError(errExec)
+
+	api := Init(config.Conf{}, stats.NewFizzbuzzCounter())
+
+	// follow the cursors from the first page to the last
+	got := []string{}
+	cursor := "0"
+	nbPages := 0
+	for cursor != "" {
+		body := fmt.Sprintf(`{"rules":[{"divisor":3,"word":"fizz"},{"divisor":5,"word":"buzz"}],"limit":23,"offset":%s,"count":5}`, cursor)
+		rr := httptest.NewRecorder()
+		api.Handler.ServeHTTP(rr, httptest.NewRequest("POST", "/v2/fizzbuzz", strings.NewReader(body)))
+		assertions.Equal(http.StatusOK, rr.Code)
+		assertions.Equal("23", rr.Header().Get("X-Total-Count"))
+
+		var page []string
+		assertions.NoError(json.Unmarshal(rr.Body.Bytes(), &page))
+		got = append(got, page...)
+		cursor = rr.Header().Get("X-Next-Cursor")
+		nbPages++
+	}
+	assertions.Equal(5, nbPages)
+	assertions.Equal(want, got)
+}
+
+func Test_Metrics(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	api := Init(config.Conf{MiddlewareMetrics: true}, stats.NewFizzbuzzCounter())
+	gotCode, _, gotErr := getFizzbuzz(api, fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"})
+	assertions.NoError(gotErr)
+	assertions.Equal(http.StatusOK, gotCode)
+	// invalid params, the error body can't be decoded by getFizzbuzz
+	getFizzbuzz(api, fizzbuzz.Params{Int1: 3, Limit: 16})
+
+	rr := httptest.NewRecorder()
+	api.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assertions.Equal(http.StatusOK, rr.Code)
+	for _, want := range []string{
+		`fizzbuzz_http_requests_total{code="200",method="GET",route="/fizzbuzz"} 1`,
+		`fizzbuzz_http_requests_total{code="400",method="GET",route="/fizzbuzz"} 1`,
+		`fizzbuzz_http_request_duration_seconds_count{route="/fizzbuzz"} 2`,
+		`fizzbuzz_limit_count{version="v1"} 1`,
+		`fizzbuzz_counter_cardinality{kind="params"} 1`,
+	} {
+		assertions.Contains(rr.Body.String(), want)
+	}
+}
+
+func Test_ResponseCache(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	// the counter already knows the most frequent request, so it is pre-warmed
+	counter := stats.NewFizzbuzzCounter()
+	frequent := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	assertions.NoError(counter.Inc(context.Background(), frequent))
+	api := Init(config.Conf{MiddlewareMetrics: true, ResponseCacheBytes: 1 << 20, ResponseCachePrewarm: 1}, counter)
+
+	// the pre-warming is left to Run, so Init does not fill the cache
+	rr := httptest.NewRecorder()
+	api.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assertions.Contains(rr.Body.String(), "fizzbuzz_response_cache_entries 0")
+	if assertions.NotNil(api.prewarm) {
+		api.prewarm()
+	}
+
+	for _, params := range []fizzbuzz.Params{frequent, frequent, {Int1: 2, Int2: 7, Limit: 16, Str1: "fazz", Str2: "bozz"}} {
+		gotCode, _, gotErr := getFizzbuzz(api, params)
+		assertions.NoError(gotErr)
+		assertions.Equal(http.StatusOK, gotCode)
+	}
+
+	rr = httptest.NewRecorder()
+	api.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		"fizzbuzz_response_cache_hits_total 2",
+		"fizzbuzz_response_cache_misses_total 1",
+		"fizzbuzz_response_cache_evictions_total 0",
+		"fizzbuzz_response_cache_entries 2",
+	} {
+		assertions.Contains(rr.Body.String(), want)
+	}
+}
+
+func Test_Init_cache(t *testing.T) {
+	t.Parallel()
+
+	counter := stats.NewFizzbuzzCounter()
+	api := Init(config.Conf{
+		MiddlewareCompression: true,
+		CompressionMinSize:    1024,
+		CompressionLevel:      1,
+		CacheMaxAge:           time.Minute,
+	}, counter)
+	server := httptest.NewServer(api.Handler)
+	defer server.Close()
+
+	get := func(acceptEncoding string, ifNoneMatch string) *http.Response {
+		req, errReq := http.NewRequest("GET", server.URL+"/fizzbuzz?int1=3&int2=5&limit=1000&str1=fizz&str2=buzz", nil)
+		require.NoError(t, errReq)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp, errResp := http.DefaultClient.Do(req)
+		require.NoError(t, errResp)
+		resp.Body.Close()
+		return resp
+	}
+
+	for _, encoding := range []string{"identity", "gzip", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			resp := get(encoding, "")
+			assertions.Equal(http.StatusOK, resp.StatusCode)
+			assertions.Equal("public, max-age=60", resp.Header.Get("Cache-Control"))
+			tag := resp.Header.Get("ETag")
+			if encoding == "identity" {
+				assertions.Regexp(`^"[0-9a-f]{32}"$`, tag)
+			} else {
+				// each compressed representation has its own tag
+				assertions.Regexp(`^"[0-9a-f]{32}-`+encoding+`"$`, tag)
+			}
+
+			resp = get(encoding, tag)
+			assertions.Equal(http.StatusNotModified, resp.StatusCode)
+			assertions.Equal(tag, resp.Header.Get("ETag"))
+			assertions.Empty(resp.Header.Get("Content-Encoding"))
+		})
+	}
+
+	// the requests answered with a 304 are counted too
+	gotCount, errGet := counter.Get(context.Background(), fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 1000, Str1: "fizz", Str2: "buzz"})
+	assert.NoError(t, errGet)
+	assert.Equal(t, 6, gotCount)
+}
+
+func Test_Init_cachePrivate(t *testing.T) {
+	t.Parallel()
+
+	api := Init(config.Conf{
+		ParsedAPIKeys: []config.APIKey{{Name: "client1", Key: "k1"}},
+		CacheMaxAge:   time.Minute,
+	}, stats.NewFizzbuzzCounter())
+	r := httptest.NewRequest("GET", "/fizzbuzz?int1=3&int2=5&limit=15&str1=fizz&str2=buzz", nil)
+	r.Header.Set("X-API-Key", "k1")
+	rr := httptest.NewRecorder()
+	api.Handler.ServeHTTP(rr, r)
+
+	// with the authentication enabled, a shared cache must not serve the output to the clients without key
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "private, max-age=60", rr.Header().Get("Cache-Control"))
+}
+
+func Test_RequestTimeout(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	api := Init(config.Conf{RequestTimeout: 10 * time.Millisecond, WriteTimeout: time.Second}, stats.NewFizzbuzzCounter())
+	assertions.Equal(time.Second, api.WriteTimeout)
+
+	// a process too long is aborted
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	api.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/fizzbuzz?int1=3&int2=5&limit=1000000000", nil))
+	assertions.Less(time.Since(start), time.Second, "process not aborted")
+	assertions.Equal(http.StatusGatewayTimeout, rr.Code)
+	assertions.Equal(`{"code":504,"desc":"request timeout"}`, rr.Body.String())
+
+	// the others are not
+	gotCode, _, gotErr := getFizzbuzz(api, fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"})
+	assertions.NoError(gotErr)
+	assertions.Equal(http.StatusOK, gotCode)
+}
+
+func Test_StreamTimeout(t *testing.T) {
+	t.Parallel()
+
+	get := func(api *Api, conf config.Conf, limit int, readDelay time.Duration) ([]byte, error) {
+		server := httptest.NewUnstartedServer(api.Handler)
+		server.Config.WriteTimeout = conf.WriteTimeout
+		server.Start()
+		defer server.Close()
+
+		resp, errGet := http.Get(fmt.Sprintf("%s/fizzbuzz?stream=true&int1=3&int2=5&str1=fizz&str2=buzz&limit=%d&format=ndjson", server.URL, limit))
+		if errGet != nil {
+			return nil, errGet
+		}
+		defer resp.Body.Close()
+		time.Sleep(readDelay)
+		return ioutil.ReadAll(resp.Body)
+	}
+
+	// the streamed response outlasts the request and write timeouts
+	conf := config.Conf{
+		RequestTimeout: 50 * time.Millisecond,
+		WriteTimeout:   100 * time.Millisecond,
+		StreamTimeout:  time.Minute,
+	}
+	body, errRead := get(Init(conf, stats.NewFizzbuzzCounter()), conf, 1000000, 200*time.Millisecond)
+	assert.NoError(t, errRead)
+	assert.True(t, bytes.HasSuffix(body, []byte("\"999998\"\n\"fizz\"\n\"buzz\"\n")), "stream truncated")
+
+	// once its own deadline passed, the response is aborted rather than terminated early, and still recorded
+	conf = config.Conf{StreamTimeout: 50 * time.Millisecond, MiddlewareMetrics: true, MiddlewareCompression: true}
+	api := Init(conf, stats.NewFizzbuzzCounter())
+	_, errRead = get(api, conf, 1000000000, 200*time.Millisecond)
+	assert.Error(t, errRead)
+	assert.Eventually(t, func() bool {
+		rr := httptest.NewRecorder()
+		api.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		return strings.Contains(rr.Body.String(), `fizzbuzz_http_requests_total{code="200",method="GET",route="/fizzbuzz"} 1`) &&
+			strings.Contains(rr.Body.String(), `fizzbuzz_http_requests_aborted_total{route="/fizzbuzz"} 1`)
+	}, time.Second, 10*time.Millisecond, "aborted stream not recorded")
+}
+
+func Test_ShutdownDrain(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	api := Init(config.Conf{DrainDelay: 200 * time.Millisecond}, stats.NewFizzbuzzCounter())
+	readyz := api.Handler
+
+	rr := httptest.NewRecorder()
+	readyz.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	assertions.Equal(http.StatusOK, rr.Code, "ready before shutdown")
+
+	start := time.Now()
+	shutdownDone := make(chan error)
+	go func() { shutdownDone <- api.Shutdown(context.Background()) }()
+
+	// the readiness fails during the drain, while the other routes are still served
+	assertions.Eventually(func() bool {
+		rr := httptest.NewRecorder()
+		readyz.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+		return rr.Code == http.StatusServiceUnavailable
+	}, time.Second, 10*time.Millisecond, "not draining")
+	gotCode, _, gotErr := getFizzbuzz(api, fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"})
+	assertions.NoError(gotErr)
+	assertions.Equal(http.StatusOK, gotCode)
+
+	assertions.NoError(<-shutdownDone)
+	assertions.GreaterOrEqual(time.Since(start), 200*time.Millisecond, "drain cut short")
+
+	// the drain does not outlast the shutdown timeout
+	api = Init(config.Conf{DrainDelay: time.Hour}, stats.NewFizzbuzzCounter())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	api.Shutdown(ctx)
+	assertions.Less(time.Since(start), time.Second, "drain not cut short")
 }
 
 func getMostFreqReq(api *Api) (int, stats.MostFrequentReq, error) {
@@ -85,7 +516,7 @@ func getMostFreqReq(api *Api) (int, stats.MostFrequentReq, error) {
 		return 0, stats.MostFrequentReq{}, fmt.Errorf("creating request: %w", errReq)
 	}
 
-	http.HandlerFunc(api.handlerWithLogs(mostfreqreqhandler.ProcessMostFrequentReq)).ServeHTTP(rr, req)
+	api.Handler.ServeHTTP(rr, req)
 
 	var response stats.MostFrequentReq
 	body, errRead := ioutil.ReadAll(rr.Result().Body)
@@ -100,6 +531,29 @@ func getMostFreqReq(api *Api) (int, stats.MostFrequentReq, error) {
 	return rr.Code, response, nil
 }
 
+func getTopReq(api *Api, k int) (int, []stats.ReqCount, error) {
+	rr := httptest.NewRecorder()
+
+	req, errReq := http.NewRequest("GET", fmt.Sprintf("/topreq?k=%d", k), nil)
+	if errReq != nil {
+		return 0, []stats.ReqCount{}, fmt.Errorf("creating request: %w", errReq)
+	}
+
+	api.Handler.ServeHTTP(rr, req)
+
+	var response []stats.ReqCount
+	body, errRead := ioutil.ReadAll(rr.Result().Body)
+	if errRead != nil {
+		return 0, []stats.ReqCount{}, fmt.Errorf("reading body: %w", errRead)
+	}
+
+	errJson := json.Unmarshal(body, &response)
+	if errJson != nil {
+		return 0, []stats.ReqCount{}, fmt.Errorf("decoding json: %w", errJson)
+	}
+	return rr.Code, response, nil
+}
+
 func getFizzbuzz(api *Api, params fizzbuzz.Params) (int, []string, error) {
 	rr := httptest.NewRecorder()
 
@@ -113,7 +567,7 @@ func getFizzbuzz(api *Api, params fizzbuzz.Params) (int, []string, error) {
 		return 0, []string{}, fmt.Errorf("creating request: %w", errReq)
 	}
 
-	http.HandlerFunc(api.handlerWithLogs(fizzbuzzhandler.ProcessFizzbuzz)).ServeHTTP(rr, req)
+	api.Handler.ServeHTTP(rr, req)
 
 	var response []string
 	body, errRead := ioutil.ReadAll(rr.Result().Body)
@@ -1,21 +1,40 @@
 package clienterr
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
+	"fizzbuzz-server/internal/requestid"
+
 	"github.com/rs/zerolog/log"
 )
 
 type ClientError struct {
 	Code int    `json:"code"`
 	Desc string `json:"desc"`
+	// RequestID allows the client to report the error, it is omitted if empty
+	RequestID string `json:"requestId,omitempty"`
 }
 
 // In case of internal error, do not send the explicit error to the client
 var InternalError ClientError = ClientError{Code: http.StatusInternalServerError, Desc: "internal error"}
 
-func (fErr ClientError) GetErrorBody() []byte {
+// TimeoutError is sent when the request is aborted because its deadline passed
+var TimeoutError ClientError = ClientError{Code: http.StatusGatewayTimeout, Desc: "request timeout"}
+
+// CanceledError is sent when the request is aborted because it was canceled, most likely by the client leaving
+var CanceledError ClientError = ClientError{Code: http.StatusServiceUnavailable, Desc: "request canceled"}
+
+// Headers gives the headers of a response whose body is an error, so it is not sniffed as plain text
+// each call gives a new map, so the caller can add its own headers
+func Headers() map[string][]string {
+	return map[string][]string{"Content-Type": {"application/json"}}
+}
+
+// GetErrorBody gives the body of the error, with the ID of the request carried by the context if any
+func (fErr ClientError) GetErrorBody(ctx context.Context) []byte {
+	fErr.RequestID = requestid.FromContext(ctx)
 	body, errJson := json.Marshal(fErr)
 	if errJson != nil {
 		log.Error().Err(errJson).Msg("error while creating error body")
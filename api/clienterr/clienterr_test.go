@@ -1,15 +1,19 @@
 package clienterr
 
 import (
+	"context"
 	"net/http"
 	"testing"
 
+	"fizzbuzz-server/internal/requestid"
+
 	"github.com/stretchr/testify/assert"
 )
 
 func Test_clientError_getErrorBody(t *testing.T) {
 	tests := map[string]struct {
 		err  ClientError
+		ctx  context.Context
 		want []byte
 	}{
 		"OK": {
@@ -17,12 +21,27 @@ func Test_clientError_getErrorBody(t *testing.T) {
 				Code: http.StatusForbidden,
 				Desc: "test error",
 			},
+			ctx:  context.Background(),
 			want: []byte(`{"code":403,"desc":"test error"}`),
 		},
+		"OK - with request ID": {
+			err:  InternalError,
+			ctx:  requestid.NewContext(context.Background(), "1234"),
+			want: []byte(`{"code":500,"desc":"internal error","requestId":"1234"}`),
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			assert.Equal(t, tt.want, tt.err.GetErrorBody())
+			assert.Equal(t, tt.want, tt.err.GetErrorBody(tt.ctx))
 		})
 	}
 }
+
+func Test_Headers(t *testing.T) {
+	headers := Headers()
+	assert.Equal(t, map[string][]string{"Content-Type": {"application/json"}}, headers)
+
+	// each call gives its own map, so the caller can add headers
+	headers["Vary"] = []string{"Accept"}
+	assert.Equal(t, map[string][]string{"Content-Type": {"application/json"}}, Headers())
+}
@@ -0,0 +1,276 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// encoder is a compressor of a response body, reset to be reused for another body
+type encoder interface {
+	io.Writer
+	Flush() error
+	Close() error
+	Reset(w io.Writer)
+}
+
+// encoding is a content coding the responses can be compressed with
+type encoding struct {
+	name string
+	pool *sync.Pool
+}
+
+// newEncodings creates the supported encodings at this level, from 1 (fastest) to 9 (smallest),
+// by order of preference when the client accepts several
+// the encoders are pooled, as creating one allocates much more than compressing a response
+func newEncodings(level int) []encoding {
+	return []encoding{
+		{name: "zstd", pool: &sync.Pool{New: func() interface{} {
+			// a single goroutine per encoder, the responses being compressed concurrently already
+			enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)), zstd.WithEncoderConcurrency(1))
+			return enc
+		}}},
+		{name: "gzip", pool: &sync.Pool{New: func() interface{} {
+			// the level is valid, so there is no error
+			enc, _ := gzip.NewWriterLevel(nil, level)
+			return enc
+		}}},
+		// the deflate content coding is the zlib format, not a raw deflate stream
+		{name: "deflate", pool: &sync.Pool{New: func() interface{} {
+			enc, _ := zlib.NewWriterLevel(nil, level)
+			return enc
+		}}},
+	}
+}
+
+// negotiateEncoding selects the encoding of the response from the Accept-Encoding header, ok is false if the
+// response must not be compressed
+// the quality of an encoding is the one of its coding, or else the one of '*'
+func negotiateEncoding(acceptEncoding string, encodings []encoding) (enc encoding, ok bool) {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		quality := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			parsed, errQuality := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if errQuality != nil || parsed < 0 || parsed > 1 {
+				continue
+			}
+			quality = parsed
+		}
+		qualities[coding] = quality
+	}
+
+	bestQuality := 0.0
+	for _, candidate := range encodings {
+		quality, found := qualities[candidate.name]
+		if !found {
+			quality = qualities["*"]
+		}
+		// ties are resolved by the order of preference of the encodings
+		if quality > bestQuality {
+			enc, ok, bestQuality = candidate, true, quality
+		}
+	}
+	return enc, ok
+}
+
+// compressionMiddleware compresses the responses with the encoding accepted by the client
+// the body is buffered until it reaches the minimum size, the smaller bodies being sent as is,
+// and streamed responses are compressed as they are flushed
+func compressionMiddleware(minSize int, level int) Middleware {
+	encodings := newEncodings(level)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the response depends on the header even when it is not compressed, so the caches must know
+			w.Header().Add("Vary", "Accept-Encoding")
+			enc, ok := negotiateEncoding(r.Header.Get("Accept-Encoding"), encodings)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.Header.Get("If-None-Match") != "" {
+				r = r.Clone(r.Context())
+				r.Header["If-None-Match"] = untagEncoding(r.Header.Values("If-None-Match"), enc.name)
+			}
+			cw := &compressWriter{ResponseWriter: w, encoding: enc, minSize: minSize}
+			// the encoder is released even if the response is aborted
+			defer cw.release()
+			next.ServeHTTP(cw, r)
+			cw.close()
+		})
+	}
+}
+
+// compressWriter compresses the body written through it once it reaches the minimum size
+// the status code is held back until it is known whether the body is compressed, as it changes the headers
+type compressWriter struct {
+	http.ResponseWriter
+	encoding encoding
+	minSize  int
+
+	code int
+	buf  bytes.Buffer
+	// decided is set once the headers are sent, enc being set if the body is compressed
+	decided bool
+	enc     encoder
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	// the informational responses are sent before the final one
+	if cw.decided || code < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.code != 0 {
+		// superfluous call, ignored as by the server
+		return
+	}
+	cw.code = code
+	if !bodyAllowed(code) {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		if cw.code == 0 {
+			cw.code = http.StatusOK
+		}
+		cw.buf.Write(p)
+		if cw.buf.Len() < cw.minSize {
+			return len(p), nil
+		}
+		if errDecide := cw.decide(true); errDecide != nil {
+			return 0, errDecide
+		}
+		return len(p), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends what was written so far, so streamed responses are compressed chunk by chunk
+// the body is sent as is if it is still smaller than the minimum size
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.code == 0 && cw.buf.Len() == 0 {
+			// nothing to send yet
+			return
+		}
+		if cw.code == 0 {
+			cw.code = http.StatusOK
+		}
+		cw.decide(cw.buf.Len() >= cw.minSize)
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives the wrapped writer, so the deadlines of the connection can be set through the compressor
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide sends the headers and the buffered body, compressing the body from now on if asked and possible
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	header := cw.Header()
+	// an already encoded body, like the metrics compressed by their handler, is not compressed twice
+	if header.Get("Content-Encoding") != "" {
+		compress = false
+	} else if tag := header.Get("ETag"); strings.HasPrefix(tag, `"`) {
+		// the compressed body is another representation, so it can't have the same strong tag
+		// the tag is changed even when the body is small enough to be sent as is, so it does not depend on the size
+		header.Set("ETag", tagEncoding(tag, cw.encoding.name))
+	}
+	if compress && bodyAllowed(cw.code) {
+		if header.Get("Content-Type") == "" {
+			// the type can't be sniffed from the compressed body anymore
+			header.Set("Content-Type", http.DetectContentType(cw.buf.Bytes()))
+		}
+		header.Set("Content-Encoding", cw.encoding.name)
+		header.Del("Content-Length")
+		cw.enc = cw.encoding.pool.Get().(encoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	if cw.code != 0 {
+		cw.ResponseWriter.WriteHeader(cw.code)
+	}
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+	var errWrite error
+	if cw.enc != nil {
+		_, errWrite = cw.enc.Write(cw.buf.Bytes())
+	} else {
+		_, errWrite = cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+	cw.buf = bytes.Buffer{}
+	return errWrite
+}
+
+// close sends the body still buffered, as is since it is smaller than the minimum size,
+// or terminates the compressed body
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.enc != nil {
+		cw.enc.Close()
+	}
+}
+
+// release returns the encoder to its pool, once the compressed body is terminated or aborted
+func (cw *compressWriter) release() {
+	if cw.enc != nil {
+		cw.enc.Reset(nil)
+		cw.encoding.pool.Put(cw.enc)
+		cw.enc = nil
+	}
+}
+
+// tagEncoding gives the strong entity tag of the representation of the entity with this tag compressed with this encoding
+func tagEncoding(tag string, encoding string) string {
+	return strings.TrimSuffix(tag, `"`) + "-" + encoding + `"`
+}
+
+// untagEncoding gives back the entity tags of these If-None-Match headers for the uncompressed representations,
+// the tags of the representations compressed with this encoding being those the client received
+func untagEncoding(ifNoneMatch []string, encoding string) []string {
+	untagged := make([]string, 0, len(ifNoneMatch))
+	for _, header := range ifNoneMatch {
+		tags := strings.Split(header, ",")
+		for i, tag := range tags {
+			tags[i] = strings.TrimSpace(tag)
+			if suffix := "-" + encoding + `"`; strings.HasSuffix(tags[i], suffix) {
+				tags[i] = strings.TrimSuffix(tags[i], suffix) + `"`
+			}
+		}
+		untagged = append(untagged, strings.Join(tags, ", "))
+	}
+	return untagged
+}
+
+// bodyAllowed tells if a response with this status code can have a body
+func bodyAllowed(code int) bool {
+	return code != http.StatusNoContent && code != http.StatusNotModified
+}
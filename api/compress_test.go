@@ -0,0 +1,257 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"fizzbuzz-server/config"
+	"fizzbuzz-server/internal/stats"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decompress decodes the body compressed with this encoding, an empty encoding meaning it is not compressed
+func decompress(t *testing.T, encoding string, body []byte) string {
+	var reader io.Reader
+	switch encoding {
+	case "":
+		return string(body)
+	case "gzip":
+		gzipReader, errGzip := gzip.NewReader(bytes.NewReader(body))
+		require.NoError(t, errGzip)
+		reader = gzipReader
+	case "deflate":
+		zlibReader, errZlib := zlib.NewReader(bytes.NewReader(body))
+		require.NoError(t, errZlib)
+		reader = zlibReader
+	case "zstd":
+		zstdReader, errZstd := zstd.NewReader(bytes.NewReader(body))
+		require.NoError(t, errZstd)
+		defer zstdReader.Close()
+		reader = zstdReader
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+	decoded, errRead := ioutil.ReadAll(reader)
+	require.NoError(t, errRead)
+	return string(decoded)
+}
+
+func Test_negotiateEncoding(t *testing.T) {
+	encodings := newEncodings(6)
+
+	tests := map[string]struct {
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		"OK - none":                       {acceptEncoding: "", wantEncoding: ""},
+		"OK - identity":                   {acceptEncoding: "identity", wantEncoding: ""},
+		"OK - gzip":                       {acceptEncoding: "gzip", wantEncoding: "gzip"},
+		"OK - case insensitive":           {acceptEncoding: "GZIP", wantEncoding: "gzip"},
+		"OK - by order of preference":     {acceptEncoding: "deflate, gzip, zstd", wantEncoding: "zstd"},
+		"OK - highest quality":            {acceptEncoding: "zstd;q=0.5, gzip;q=0.8, deflate", wantEncoding: "deflate"},
+		"OK - any":                        {acceptEncoding: "*", wantEncoding: "zstd"},
+		"OK - any but refused":            {acceptEncoding: "zstd;q=0, *;q=0.5", wantEncoding: "gzip"},
+		"OK - all refused":                {acceptEncoding: "gzip;q=0, deflate;q=0", wantEncoding: ""},
+		"OK - malformed quality ignored":  {acceptEncoding: "zstd;q=fizz, gzip", wantEncoding: "gzip"},
+		"OK - unsupported coding ignored": {acceptEncoding: "br, deflate", wantEncoding: "deflate"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			gotEncoding, gotOk := negotiateEncoding(tt.acceptEncoding, encodings)
+			assert.Equal(t, tt.wantEncoding != "", gotOk)
+			assert.Equal(t, tt.wantEncoding, gotEncoding.name)
+		})
+	}
+}
+
+func Test_compressionMiddleware(t *testing.T) {
+	t.Parallel()
+
+	large := strings.Repeat(`"fizz","buzz",`, 100)
+	tests := map[string]struct {
+		acceptEncoding  string
+		handler         http.HandlerFunc
+		wantCode        int
+		wantEncoding    string
+		wantContentType string
+		wantBody        string
+	}{
+		"OK - compressed": {
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				io.WriteString(w, large)
+			},
+			wantCode:        http.StatusCreated,
+			wantEncoding:    "gzip",
+			wantContentType: "application/json",
+			wantBody:        large,
+		},
+		"OK - compressed when reaching the minimum size": {
+			acceptEncoding: "deflate",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				for i := 0; i < 100; i++ {
+					io.WriteString(w, `"fizz","buzz",`)
+				}
+			},
+			wantCode:        http.StatusOK,
+			wantEncoding:    "deflate",
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        large,
+		},
+		"OK - streamed": {
+			acceptEncoding: "zstd",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				for i := 0; i < 10; i++ {
+					io.WriteString(w, large)
+					w.(http.Flusher).Flush()
+				}
+			},
+			wantCode:        http.StatusOK,
+			wantEncoding:    "zstd",
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        strings.Repeat(large, 10),
+		},
+		"OK - smaller than the minimum size": {
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"code":400,"desc":"invalid params"}`)
+			},
+			wantCode:        http.StatusBadRequest,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        `{"code":400,"desc":"invalid params"}`,
+		},
+		"OK - smaller than the minimum size but flushed": {
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, `["1"]`)
+				w.(http.Flusher).Flush()
+				io.WriteString(w, large)
+			},
+			wantCode:        http.StatusOK,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        `["1"]` + large,
+		},
+		"OK - not accepted": {
+			acceptEncoding: "identity",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, large)
+			},
+			wantCode:        http.StatusOK,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        large,
+		},
+		"OK - already encoded": {
+			acceptEncoding: "gzip, zstd",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+				gzipWriter := gzip.NewWriter(w)
+				io.WriteString(gzipWriter, large)
+				gzipWriter.Close()
+			},
+			wantCode:     http.StatusOK,
+			wantEncoding: "gzip",
+			wantBody:     large,
+		},
+		"OK - no body": {
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			wantCode: http.StatusNoContent,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assertions := assert.New(t)
+
+			server := httptest.NewServer(compressionMiddleware(100, 6)(tt.handler))
+			defer server.Close()
+			req, errReq := http.NewRequest("GET", server.URL, nil)
+			require.NoError(t, errReq)
+			// set explicitly, so the client does not decompress the body
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			resp, errResp := http.DefaultClient.Do(req)
+			require.NoError(t, errResp)
+			defer resp.Body.Close()
+			body, errRead := ioutil.ReadAll(resp.Body)
+			require.NoError(t, errRead)
+
+			assertions.Equal(tt.wantCode, resp.StatusCode)
+			assertions.Equal([]string{"Accept-Encoding"}, resp.Header.Values("Vary"))
+			assertions.Equal(tt.wantEncoding, resp.Header.Get("Content-Encoding"))
+			assertions.Equal(tt.wantContentType, resp.Header.Get("Content-Type"))
+			assertions.Equal(tt.wantBody, decompress(t, tt.wantEncoding, body))
+		})
+	}
+}
+
+func Test_Init_compression(t *testing.T) {
+	t.Parallel()
+
+	api := Init(config.Conf{
+		MiddlewareCompression: true,
+		CompressionMinSize:    1024,
+		CompressionLevel:      1,
+	}, stats.NewFizzbuzzCounter())
+	server := httptest.NewServer(api.Handler)
+	defer server.Close()
+
+	get := func(query string, acceptEncoding string) *http.Response {
+		req, errReq := http.NewRequest("GET", server.URL+"/fizzbuzz?int1=3&int2=5&str1=fizz&str2=buzz&"+query, nil)
+		require.NoError(t, errReq)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		resp, errResp := http.DefaultClient.Do(req)
+		require.NoError(t, errResp)
+		return resp
+	}
+	readAll := func(resp *http.Response) []byte {
+		defer resp.Body.Close()
+		body, errRead := ioutil.ReadAll(resp.Body)
+		require.NoError(t, errRead)
+		return body
+	}
+
+	// buffered and streamed responses are compressed, and decompressed are the same as uncompressed
+	identity := readAll(get("limit=100000", "identity"))
+	for _, encoding := range []string{"gzip", "deflate", "zstd"} {
+		for _, query := range []string{"limit=100000", "limit=100000&stream=true"} {
+			t.Run(encoding+" "+query, func(t *testing.T) {
+				assertions := assert.New(t)
+
+				resp := get(query, encoding)
+				body := readAll(resp)
+				assertions.Equal(encoding, resp.Header.Get("Content-Encoding"))
+				assertions.Equal("application/json", resp.Header.Get("Content-Type"))
+				assertions.ElementsMatch([]string{"Accept-Encoding", "Accept"}, resp.Header.Values("Vary"))
+				assertions.Less(len(body), len(identity)/3)
+				assertions.Equal(string(identity), decompress(t, encoding, body))
+			})
+		}
+	}
+
+	// small responses are sent as is
+	resp := get("limit=10", "gzip")
+	assert.Equal(t, `["1","2","fizz","4","buzz","fizz","7","8","fizz","buzz"]`, string(readAll(resp)))
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+}
+
+func Test_untagEncoding(t *testing.T) {
+	assert.Equal(t,
+		[]string{`"fizz", "buzz"`, `W/"fizz", "buzz-zstd", *`},
+		untagEncoding([]string{`"fizz-gzip", "buzz"`, `W/"fizz-gzip","buzz-zstd", *`}, "gzip"))
+}
@@ -0,0 +1,62 @@
+package docshandler
+
+import (
+	_ "embed"
+	"net/http"
+
+	"fizzbuzz-server/internal/stats"
+)
+
+// Spec is the OpenAPI document describing the routes of the API
+//
+//go:embed openapi.json
+var Spec []byte
+
+// docsPage is the Swagger UI page browsing the OpenAPI document
+//
+//go:embed docs.html
+var docsPage []byte
+
+// swaggerUICSS and swaggerUIBundle are the Swagger UI files used by the documentation page, vendored so the page
+// works offline and runs no third-party script fetched at runtime
+//
+//go:embed swagger-ui/swagger-ui.css
+var swaggerUICSS []byte
+
+//go:embed swagger-ui/swagger-ui-bundle.js
+var swaggerUIBundle []byte
+
+// assetMaxAge is how long the clients can keep the Swagger UI files, which only change with the binary
+const assetMaxAge = "public, max-age=86400"
+
+// ProcessOpenAPI does all the process of a request of the OpenAPI document
+func ProcessOpenAPI(_ *http.Request, _ stats.Counter) (int, map[string][]string, []byte, error) {
+	return http.StatusOK,
+		map[string][]string{"Content-Type": {"application/json"}},
+		Spec,
+		nil
+}
+
+// ProcessDocs does all the process of a request of the documentation page
+func ProcessDocs(_ *http.Request, _ stats.Counter) (int, map[string][]string, []byte, error) {
+	return http.StatusOK,
+		map[string][]string{"Content-Type": {"text/html; charset=utf-8"}},
+		docsPage,
+		nil
+}
+
+// ProcessSwaggerUICSS does all the process of a request of the Swagger UI stylesheet
+func ProcessSwaggerUICSS(_ *http.Request, _ stats.Counter) (int, map[string][]string, []byte, error) {
+	return http.StatusOK,
+		map[string][]string{"Content-Type": {"text/css; charset=utf-8"}, "Cache-Control": {assetMaxAge}},
+		swaggerUICSS,
+		nil
+}
+
+// ProcessSwaggerUIBundle does all the process of a request of the Swagger UI script
+func ProcessSwaggerUIBundle(_ *http.Request, _ stats.Counter) (int, map[string][]string, []byte, error) {
+	return http.StatusOK,
+		map[string][]string{"Content-Type": {"text/javascript; charset=utf-8"}, "Cache-Control": {assetMaxAge}},
+		swaggerUIBundle,
+		nil
+}
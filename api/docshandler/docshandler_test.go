@@ -0,0 +1,72 @@
+package docshandler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"fizzbuzz-server/internal/stats"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ProcessOpenAPI(t *testing.T) {
+	assertions := assert.New(t)
+
+	gotCode, gotHeaders, gotBody, gotErr := ProcessOpenAPI(&http.Request{Method: "GET"}, stats.NewFizzbuzzCounter())
+
+	assertions.NoError(gotErr)
+	assertions.Equal(http.StatusOK, gotCode)
+	assertions.Equal(map[string][]string{"Content-Type": {"application/json"}}, gotHeaders)
+	spec := struct {
+		OpenAPI string `json:"openapi"`
+	}{}
+	assertions.NoError(json.Unmarshal(gotBody, &spec))
+	assertions.Equal("3.0.3", spec.OpenAPI)
+}
+
+func Test_ProcessDocs(t *testing.T) {
+	assertions := assert.New(t)
+
+	gotCode, gotHeaders, gotBody, gotErr := ProcessDocs(&http.Request{Method: "GET"}, stats.NewFizzbuzzCounter())
+
+	assertions.NoError(gotErr)
+	assertions.Equal(http.StatusOK, gotCode)
+	assertions.Equal(map[string][]string{"Content-Type": {"text/html; charset=utf-8"}}, gotHeaders)
+	assertions.Contains(string(gotBody), `url: "openapi.json"`)
+	// no third-party resource is loaded
+	assertions.NotContains(string(gotBody), "://")
+}
+
+func Test_ProcessSwaggerUI(t *testing.T) {
+	tests := map[string]struct {
+		process         func(*http.Request, stats.Counter) (int, map[string][]string, []byte, error)
+		wantContentType string
+		wantPrefix      string
+	}{
+		"OK - stylesheet": {
+			process:         ProcessSwaggerUICSS,
+			wantContentType: "text/css; charset=utf-8",
+			wantPrefix:      ".swagger-ui{",
+		},
+		"OK - script": {
+			process:         ProcessSwaggerUIBundle,
+			wantContentType: "text/javascript; charset=utf-8",
+			wantPrefix:      "/*! For license information",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			gotCode, gotHeaders, gotBody, gotErr := tt.process(&http.Request{Method: "GET"}, stats.NewFizzbuzzCounter())
+
+			assertions.NoError(gotErr)
+			assertions.Equal(http.StatusOK, gotCode)
+			assertions.Equal([]string{tt.wantContentType}, gotHeaders["Content-Type"])
+			assertions.Equal([]string{"public, max-age=86400"}, gotHeaders["Cache-Control"])
+			assertions.True(strings.HasPrefix(string(gotBody), tt.wantPrefix))
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package fizzbuzzhandler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"fizzbuzz-server/internal/fizzbuzz"
+	"fizzbuzz-server/internal/stats"
+)
+
+// cacheKey gives the canonical representation of the output of these params in this format
+// the output is a pure function of the params, so two requests with the same key get the same body
+func cacheKey(params fizzbuzz.Params, f format) string {
+	return f.name + "\n" + params.RuleSet().Key()
+}
+
+// etag gives the strong entity tag of the output of these params in this format
+func etag(params fizzbuzz.Params, f format) string {
+	sum := sha256.Sum256([]byte(cacheKey(params, f)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// cacheHeaders gives the headers letting the clients and the caches keep the output, identified by its tag,
+// for the max age
+// a private output can only be kept by the clients, not by the shared caches
+func cacheHeaders(tag string, maxAge time.Duration, private bool) map[string][]string {
+	scope := "public"
+	if private {
+		scope = "private"
+	}
+	return map[string][]string{
+		"ETag":          {tag},
+		"Cache-Control": {fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds()))},
+	}
+}
+
+// notModified tells if the client already has the output with this tag, according to its If-None-Match header
+// the tags are compared weakly, as required for this header
+func notModified(r *http.Request, tag string) bool {
+	for _, header := range r.Header.Values("If-None-Match") {
+		for _, candidate := range strings.Split(header, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Prewarm fills the response cache with the outputs of the k most frequent requests of the counter,
+// in the default format, and gives the number of outputs cached
+// the requests no longer accepted, like those exceeding the max limit, are skipped
+func (h Handler) Prewarm(ctx context.Context, counter stats.Counter, k int) (int, error) {
+	if h.Cache == nil || k <= 0 {
+		return 0, nil
+	}
+	top, errTop := counter.TopK(ctx, k)
+	if errTop != nil {
+		return 0, fmt.Errorf("retrieving most frequent requests: %w", errTop)
+	}
+
+	cached := 0
+	for _, reqCount := range top {
+		if _, _, errParams := h.checkParams(reqCount.Params); errParams != nil {
+			continue
+		}
+		output, errExec := fizzbuzz.ExecFizzbuzz(ctx, reqCount.Params)
+		if errExec != nil {
+			return cached, fmt.Errorf("executing fizzbuzz: %w", errExec)
+		}
+		body, errEncode := formats[0].encode(output)
+		if errEncode != nil {
+			return cached, fmt.Errorf("encoding output: %w", errEncode)
+		}
+		h.Cache.Add(cacheKey(reqCount.Params, formats[0]), body)
+		cached++
+	}
+	return cached, nil
+}
@@ -0,0 +1,124 @@
+package fizzbuzzhandler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"fizzbuzz-server/internal/fizzbuzz"
+	"fizzbuzz-server/internal/lrucache"
+	"fizzbuzz-server/internal/stats"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_notModified(t *testing.T) {
+	tag := `"fizzbuzz"`
+
+	tests := map[string]struct {
+		ifNoneMatch []string
+		want        bool
+	}{
+		"OK - no header":       {want: false},
+		"OK - same tag":        {ifNoneMatch: []string{`"fizzbuzz"`}, want: true},
+		"OK - weak tag":        {ifNoneMatch: []string{`W/"fizzbuzz"`}, want: true},
+		"OK - any tag":         {ifNoneMatch: []string{`*`}, want: true},
+		"OK - one of the tags": {ifNoneMatch: []string{`"fizz" ,"fizzbuzz"`}, want: true},
+		"OK - several headers": {ifNoneMatch: []string{`"fizz"`, `"fizzbuzz"`}, want: true},
+		"OK - other tags":      {ifNoneMatch: []string{`"fizz", "buzz"`}, want: false},
+		"OK - unquoted tag":    {ifNoneMatch: []string{`fizzbuzz`}, want: false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := &http.Request{Header: http.Header{"If-None-Match": tt.ifNoneMatch}}
+			assert.Equal(t, tt.want, notModified(r, tag))
+		})
+	}
+}
+
+func Test_Handler_responseCache(t *testing.T) {
+	assertions := assert.New(t)
+
+	handler := Handler{Cache: lrucache.New(1 << 20)}
+	counter := stats.NewFizzbuzzCounter()
+	newRequest := func(query string) *http.Request {
+		return &http.Request{
+			Method: "GET",
+			URL:    &url.URL{RawQuery: "int1=3&int2=5&limit=15&str1=fizz&str2=buzz&" + query},
+			Header: http.Header{},
+			Body:   http.NoBody,
+		}
+	}
+
+	// computed then cached
+	gotCode, _, gotBody, gotErr := handler.ProcessFizzbuzz(newRequest(""), counter)
+	assertions.NoError(gotErr)
+	assertions.Equal(http.StatusOK, gotCode)
+	assertions.Equal(lrucache.Stats{Misses: 1, Bytes: handler.Cache.Stats().Bytes, Entries: 1}, handler.Cache.Stats())
+
+	// the same params are answered from the cache, streamed or not
+	_, gotHeaders, gotCached, gotErr := handler.ProcessFizzbuzz(newRequest(""), counter)
+	assertions.NoError(gotErr)
+	assertions.Equal(gotBody, gotCached)
+	assertions.Equal(jsonHeaders["Content-Type"], gotHeaders["Content-Type"])
+	_, _, gotWriteBody, gotErr := handler.StreamFizzbuzz(newRequest("stream=true"), counter)
+	assertions.NoError(gotErr)
+	gotStreamed := bytes.Buffer{}
+	assertions.NoError(gotWriteBody(&gotStreamed))
+	assertions.Equal(gotBody, gotStreamed.Bytes())
+	// the streamed requests are not counted, as they are never added to the cache
+	_, _, _, gotErr = handler.StreamFizzbuzz(newRequest("stream=true&format=text"), counter)
+	assertions.NoError(gotErr)
+	assertions.Equal(uint64(1), handler.Cache.Stats().Hits)
+	assertions.Equal(uint64(1), handler.Cache.Stats().Misses)
+
+	// each format is cached apart
+	_, _, gotCSV, _ := handler.ProcessFizzbuzz(newRequest("format=csv"), counter)
+	assertions.NotEqual(gotBody, gotCSV)
+	assertions.Equal(2, handler.Cache.Stats().Entries)
+
+	// the cached requests are counted anyway
+	gotCount, gotErr := counter.Get(context.Background(), fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 15, Str1: "fizz", Str2: "buzz"})
+	assertions.NoError(gotErr)
+	assertions.Equal(5, gotCount)
+}
+
+func Test_Handler_Prewarm(t *testing.T) {
+	assertions := assert.New(t)
+
+	counter := stats.NewFizzbuzzCounter()
+	frequent := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 15, Str1: "fizz", Str2: "buzz"}
+	tooLarge := fizzbuzz.Params{Int1: 2, Int2: 7, Limit: 1000, Str1: "fizz", Str2: "buzz"}
+	rare := fizzbuzz.Params{Int1: 2, Int2: 3, Limit: 10, Str1: "fizz", Str2: "buzz"}
+	for params, count := range map[fizzbuzz.Params]int{frequent: 3, tooLarge: 2, rare: 1} {
+		for i := 0; i < count; i++ {
+			assertions.NoError(counter.Inc(context.Background(), params))
+		}
+	}
+
+	handler := Handler{MaxLimit: 100, Cache: lrucache.New(1 << 20)}
+	gotCached, gotErr := handler.Prewarm(context.Background(), counter, 2)
+	assertions.NoError(gotErr)
+	// the request exceeding the max limit is skipped, the rare one is not among the most frequent
+	assertions.Equal(1, gotCached)
+	_, ok := handler.Cache.Get(cacheKey(frequent, formats[0]))
+	assertions.True(ok)
+
+	// the pre-warmed output is the one computed on request
+	_, _, gotBody, gotErr := Handler{}.ProcessFizzbuzz(&http.Request{
+		Method: "GET",
+		URL:    &url.URL{RawQuery: "int1=3&int2=5&limit=15&str1=fizz&str2=buzz"},
+		Header: http.Header{},
+		Body:   http.NoBody,
+	}, counter)
+	assertions.NoError(gotErr)
+	gotCachedBody, _ := handler.Cache.Get(cacheKey(frequent, formats[0]))
+	assertions.Equal(gotBody, gotCachedBody)
+
+	// nothing to do without cache
+	gotCached, gotErr = Handler{}.Prewarm(context.Background(), counter, 2)
+	assertions.NoError(gotErr)
+	assertions.Equal(0, gotCached)
+}
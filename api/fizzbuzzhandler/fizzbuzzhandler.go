@@ -1,75 +1,254 @@
 package fizzbuzzhandler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"fizzbuzz-server/api/clienterr"
 	"fizzbuzz-server/internal/fizzbuzz"
+	"fizzbuzz-server/internal/lrucache"
 	"fizzbuzz-server/internal/stats"
 )
 
+// Handler processes the fizzbuzz requests, rejecting the ones exceeding its maximums
+// a maximum set to zero or less is not enforced
+type Handler struct {
+	// MaxLimit is the greatest limit param accepted
+	MaxLimit int
+	// MaxStreamLimit is the greatest limit param accepted for a streamed output, whose memory use does not depend on it
+	MaxStreamLimit int
+	// MaxBodyBytes is the greatest request body size accepted
+	MaxBodyBytes int64
+	// CacheMaxAge is how long the clients and the caches can keep an output
+	CacheMaxAge time.Duration
+	// PrivateCache keeps the outputs out of the shared caches, which would serve them to unauthenticated clients
+	// and hide the requests from the statistics
+	PrivateCache bool
+	// Cache keeps the serialized outputs, so the most frequent ones are not computed again, nil disabling it
+	Cache *lrucache.Cache
+}
+
 // ProcessFizzbuzz does all the process of a fizzbuzz request
-func ProcessFizzbuzz(r *http.Request, counter stats.FizzbuzzCounter) (int, map[string][]string, []byte, error) {
-	// check method
-	if r.Method != "GET" {
-		return http.StatusMethodNotAllowed,
-			map[string][]string{"Allow": {"GET"}},
-			clienterr.ClientError{Code: http.StatusMethodNotAllowed, Desc: "method not allowed"}.GetErrorBody(),
-			errors.New("invalid method")
+func (h Handler) ProcessFizzbuzz(r *http.Request, counter stats.Counter) (int, map[string][]string, []byte, error) {
+	params, f, code, headers, errBody, errPrepare := h.prepareFizzbuzz(r, counter)
+	if errPrepare != nil || code == http.StatusNotModified {
+		return code, headers, errBody, errPrepare
+	}
+	key := cacheKey(params, f)
+	if h.Cache != nil {
+		if body, ok := h.Cache.Get(key); ok {
+			return http.StatusOK, headers, body, nil
+		}
 	}
 
-	// read body
-	reqBody, errRead := ioutil.ReadAll(r.Body)
-	if errRead != nil {
+	// execute fizzbuzz
+	output, errExec := fizzbuzz.ExecFizzbuzz(r.Context(), params)
+	if errExec != nil {
+		clientErr := execClientError(errExec)
+		return clientErr.Code,
+			clienterr.Headers(),
+			clientErr.GetErrorBody(r.Context()),
+			fmt.Errorf("error executing fizzbuzz: %w", errExec)
+	}
+
+	// create response
+	body, errEncode := f.encode(output)
+	if errEncode != nil {
+		return http.StatusInternalServerError,
+			clienterr.Headers(),
+			clienterr.InternalError.GetErrorBody(r.Context()),
+			fmt.Errorf("error encoding output: %w", errEncode)
+	}
+	if h.Cache != nil {
+		h.Cache.Add(key, body)
+	}
+	return http.StatusOK,
+		headers,
+		body,
+		nil
+}
+
+// StreamFizzbuzz does all the process of a fizzbuzz request, like ProcessFizzbuzz,
+// but the output is generated while the body is written so the memory used does not depend on the limit
+// a cached output is sent as is, but a streamed one is not cached, as it is not kept in memory, and the lookup is not
+// counted in the statistics of the cache
+func (h Handler) StreamFizzbuzz(r *http.Request, counter stats.Counter) (int, map[string][]string, func(io.Writer) error, error) {
+	// the receiver is a copy, the streamed outputs are only bounded by their own maximum
+	h.MaxLimit = h.MaxStreamLimit
+	params, f, code, headers, errBody, errPrepare := h.prepareFizzbuzz(r, counter)
+	if errPrepare != nil || code == http.StatusNotModified {
+		return code, headers, writeBytes(errBody), errPrepare
+	}
+	// peeked, as a streamed output missing from the cache is not added to it, so it would always be a miss
+	if h.Cache != nil {
+		if body, ok := h.Cache.Peek(cacheKey(params, f)); ok {
+			return http.StatusOK, headers, writeBytes(body), nil
+		}
+	}
+
+	// create generator
+	gen, errGen := fizzbuzz.NewGenerator(r.Context(), params)
+	if errGen != nil {
 		return http.StatusInternalServerError,
-			map[string][]string{},
-			clienterr.InternalError.GetErrorBody(),
-			fmt.Errorf("error reading body: %w", errRead)
+			clienterr.Headers(),
+			writeBytes(clienterr.InternalError.GetErrorBody(r.Context())),
+			fmt.Errorf("error creating fizzbuzz generator: %w", errGen)
+	}
+
+	return http.StatusOK,
+		headers,
+		func(w io.Writer) error { return f.write(w, gen) },
+		nil
+}
+
+// prepareFizzbuzz negotiates the format of the response, retrieves and checks the params of the request then counts it
+// it returns the status code and headers of the response, and in case of error its body
+// the status code is 304 if the client already has the output, which then does not need to be computed
+func (h Handler) prepareFizzbuzz(r *http.Request, counter stats.Counter) (fizzbuzz.Params, format, int, map[string][]string, []byte, error) {
+	// negotiate format
+	f, clientErr, errFormat := negotiateFormat(r)
+	if errFormat != nil {
+		return fizzbuzz.Params{},
+			format{},
+			clientErr.Code,
+			negotiationErrorHeaders(),
+			clientErr.GetErrorBody(r.Context()),
+			fmt.Errorf("negotiating format: %w", errFormat)
 	}
 
 	// retrieve and check params
-	params, clientErr, errParams := getParamsFizzbuzz(reqBody)
+	params, clientErr, errParams := h.getParamsFizzbuzz(r)
 	if errParams != nil {
-		return http.StatusBadRequest,
-			map[string][]string{},
-			clientErr.GetErrorBody(),
+		return fizzbuzz.Params{},
+			format{},
+			clientErr.Code,
+			clienterr.Headers(),
+			clientErr.GetErrorBody(r.Context()),
 			fmt.Errorf("invalid params: %w", errParams)
 	}
 
 	// increment counter
-	counter.Inc(params)
+	if errInc := counter.Inc(r.Context(), params); errInc != nil {
+		return fizzbuzz.Params{},
+			format{},
+			http.StatusInternalServerError,
+			clienterr.Headers(),
+			clienterr.InternalError.GetErrorBody(r.Context()),
+			fmt.Errorf("error incrementing counter: %w", errInc)
+	}
 
-	// execute fizzbuzz
-	output, errExec := fizzbuzz.ExecFizzbuzz(params)
-	if errExec != nil {
-		return http.StatusInternalServerError,
-			map[string][]string{},
-			clienterr.InternalError.GetErrorBody(),
-			fmt.Errorf("error executing fizzbuzz: %w", errExec)
+	headers := formatHeaders(f)
+	tag := etag(params, f)
+	for key, values := range cacheHeaders(tag, h.CacheMaxAge, h.PrivateCache) {
+		headers[key] = values
+	}
+	// the request is counted anyway, the client having the output already is not known to the stats
+	if notModified(r, tag) {
+		return params, f, http.StatusNotModified, headers, nil, nil
 	}
+	return params, f, http.StatusOK, headers, nil, nil
+}
 
-	// create response
-	body, errJson := json.Marshal(output)
-	if errJson != nil {
-		return http.StatusInternalServerError,
-			map[string][]string{},
-			clienterr.InternalError.GetErrorBody(),
-			fmt.Errorf("error marshalling json: %w", errJson)
+// formatHeaders gives the headers describing the format of the response
+// the format depends on the Accept header, so caches must not send it to the clients accepting other types
+func formatHeaders(f format) map[string][]string {
+	return map[string][]string{"Content-Type": {f.contentType}, "Vary": {"Accept"}}
+}
+
+// negotiationErrorHeaders gives the headers of the response when no format is acceptable,
+// which also depends on the Accept header
+func negotiationErrorHeaders() map[string][]string {
+	headers := clienterr.Headers()
+	headers["Vary"] = []string{"Accept"}
+	return headers
+}
+
+// execClientError gives the error sent to the client when the fizzbuzz process failed
+// a process aborted because the deadline of the request passed or the client left is not an internal error
+func execClientError(errExec error) clienterr.ClientError {
+	switch {
+	case errors.Is(errExec, context.DeadlineExceeded):
+		return clienterr.TimeoutError
+	case errors.Is(errExec, context.Canceled):
+		return clienterr.CanceledError
+	default:
+		return clienterr.InternalError
 	}
-	return http.StatusOK,
-		map[string][]string{},
-		body,
-		nil
 }
 
-// getParamsFizzbuzz retrieves and checks params from the body
+// writeBytes creates a func writing this body as is
+func writeBytes(body []byte) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, errWrite := w.Write(body)
+		return errWrite
+	}
+}
+
+// readBody reads the request body, up to MaxBodyBytes
+// it returns two versions of the error if needed, one for the client and one more precise for internal use
+func (h Handler) readBody(r *http.Request) ([]byte, clienterr.ClientError, error) {
+	if h.MaxBodyBytes <= 0 {
+		body, errRead := ioutil.ReadAll(r.Body)
+		if errRead != nil {
+			return nil, clienterr.InternalError, errRead
+		}
+		return body, clienterr.ClientError{}, nil
+	}
+
+	// read one more byte than allowed to detect larger bodies
+	body, errRead := ioutil.ReadAll(io.LimitReader(r.Body, h.MaxBodyBytes+1))
+	if errRead != nil {
+		return nil, clienterr.InternalError, errRead
+	}
+	if int64(len(body)) > h.MaxBodyBytes {
+		errStr := fmt.Sprintf("request body too large (max %d bytes)", h.MaxBodyBytes)
+		return nil, clienterr.ClientError{Code: http.StatusRequestEntityTooLarge, Desc: errStr}, errors.New(errStr)
+	}
+	return body, clienterr.ClientError{}, nil
+}
+
+// getParamsFizzbuzz retrieves and checks params from the request
+// on GET they are read from the query string, or from a JSON body if the query string contains none of them
+// on POST they are read from the body, either form-encoded or JSON depending on its content type
 // it returns two versions of the error if needed, one for the client and one more precise for internal use
-func getParamsFizzbuzz(body []byte) (fizzbuzz.Params, clienterr.ClientError, error) {
+func (h Handler) getParamsFizzbuzz(r *http.Request) (fizzbuzz.Params, clienterr.ClientError, error) {
+	if r.Method == "GET" && r.URL != nil && hasParams(r.URL.Query()) {
+		params, clientErr, errValues := getParamsFromValues(r.URL.Query())
+		if errValues != nil {
+			return fizzbuzz.Params{}, clientErr, fmt.Errorf("decoding query string: %w", errValues)
+		}
+		return h.checkParams(params)
+	}
+
+	body, clientErr, errRead := h.readBody(r)
+	if errRead != nil {
+		return fizzbuzz.Params{}, clientErr, fmt.Errorf("reading body: %w", errRead)
+	}
+
+	if isFormEncoded(r) {
+		values, errParse := url.ParseQuery(string(body))
+		if errParse != nil {
+			return fizzbuzz.Params{},
+				clienterr.ClientError{Code: http.StatusBadRequest, Desc: "invalid params"},
+				fmt.Errorf("parsing form: %w", errParse)
+		}
+		params, clientErr, errValues := getParamsFromValues(values)
+		if errValues != nil {
+			return fizzbuzz.Params{}, clientErr, fmt.Errorf("decoding form: %w", errValues)
+		}
+		return h.checkParams(params)
+	}
+
 	params := fizzbuzz.Params{}
 	errJson := json.Unmarshal(body, &params)
 	if errJson != nil {
@@ -77,7 +256,64 @@ func getParamsFizzbuzz(body []byte) (fizzbuzz.Params, clienterr.ClientError, err
 			clienterr.ClientError{Code: http.StatusBadRequest, Desc: "invalid params"},
 			fmt.Errorf("unmarshalling json: %w", errJson)
 	}
+	return h.checkParams(params)
+}
+
+// hasParams tells if any of the fizzbuzz params is set in these values
+func hasParams(values url.Values) bool {
+	for _, key := range []string{"int1", "int2", "limit", "str1", "str2"} {
+		if values.Has(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFormEncoded tells if the request body is form-encoded, according to its content type
+func isFormEncoded(r *http.Request) bool {
+	mediaType, _, errParse := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return errParse == nil && mediaType == "application/x-www-form-urlencoded"
+}
 
+// getParamsFromValues retrieves params from a query string or a form, missing values are left to zero
+// it returns two versions of the error if needed, one for the client and one more precise for internal use
+func getParamsFromValues(values url.Values) (fizzbuzz.Params, clienterr.ClientError, error) {
+	params := fizzbuzz.Params{
+		Str1: values.Get("str1"),
+		Str2: values.Get("str2"),
+	}
+
+	strBuilder := strings.Builder{}
+	for _, intParam := range []struct {
+		key   string
+		value *int
+	}{
+		{key: "int1", value: &params.Int1},
+		{key: "int2", value: &params.Int2},
+		{key: "limit", value: &params.Limit},
+	} {
+		if !values.Has(intParam.key) {
+			continue
+		}
+		value, errConv := strconv.Atoi(values.Get(intParam.key))
+		if errConv != nil {
+			strBuilder.WriteString(intParam.key + " must be an integer, ")
+			continue
+		}
+		*intParam.value = value
+	}
+	if errStr := strBuilder.String(); errStr != "" {
+		// remove trailing comma and space
+		errStr = errStr[:len(errStr)-2]
+		return params, clienterr.ClientError{Code: http.StatusBadRequest, Desc: errStr}, errors.New(errStr)
+	}
+
+	return params, clienterr.ClientError{}, nil
+}
+
+// checkParams checks the params are valid for the fizzbuzz process
+// it returns two versions of the error if needed, one for the client and one more precise for internal use
+func (h Handler) checkParams(params fizzbuzz.Params) (fizzbuzz.Params, clienterr.ClientError, error) {
 	strBuilder := strings.Builder{}
 	if params.Int1 == 0 {
 		strBuilder.WriteString("int1 missing (can't be zero), ")
@@ -89,6 +325,8 @@ func getParamsFizzbuzz(body []byte) (fizzbuzz.Params, clienterr.ClientError, err
 		strBuilder.WriteString("limit missing (can't be inferior to one), ")
 	} else if params.Limit < 0 {
 		strBuilder.WriteString("limit must be superior to one, ")
+	} else if h.MaxLimit > 0 && params.Limit > h.MaxLimit {
+		strBuilder.WriteString(fmt.Sprintf("limit must be inferior or equal to %d, ", h.MaxLimit))
 	}
 	if errStr := strBuilder.String(); errStr != "" {
 		// remove trailing comma and space
@@ -1,10 +1,16 @@
 package fizzbuzzhandler
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"fizzbuzz-server/internal/fizzbuzz"
 	"fizzbuzz-server/internal/stats"
@@ -12,10 +18,22 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// jsonHeaders are the headers of a successful response in the default format
+var jsonHeaders = map[string][]string{"Content-Type": {"application/json"}, "Vary": {"Accept"}}
+
+// errorHeaders are the headers of a response with an error body
+var errorHeaders = map[string][]string{"Content-Type": {"application/json"}}
+
 func Test_ProcessFizzbuzz(t *testing.T) {
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
 	tests := map[string]struct {
+		handler     Handler
 		req         *http.Request
-		counter     stats.FizzbuzzCounter
+		counter     stats.Counter
 		wantCode    int
 		wantHeaders map[string][]string
 		wantBody    []byte
@@ -34,25 +52,221 @@ func Test_ProcessFizzbuzz(t *testing.T) {
 			},
 			counter:     stats.NewFizzbuzzCounter(),
 			wantCode:    http.StatusOK,
-			wantHeaders: map[string][]string{},
+			wantHeaders: jsonHeaders,
+			wantBody:    []byte(`["1","2","fizz","buzz","5","fizz","7","buzz","fizz","10","11","fizzbuzz"]`),
+		},
+		"OK - POST form": {
+			req: &http.Request{
+				Method: "POST",
+				Header: http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+				Body:   ioutil.NopCloser(strings.NewReader(`int1=3&int2=4&limit=12&str1=fizz&str2=buzz`)),
+			},
+			counter:     stats.NewFizzbuzzCounter(),
+			wantCode:    http.StatusOK,
+			wantHeaders: jsonHeaders,
 			wantBody:    []byte(`["1","2","fizz","buzz","5","fizz","7","buzz","fizz","10","11","fizzbuzz"]`),
 		},
-		"KO - method not allowed": {
+		"OK - POST JSON": {
 			req: &http.Request{
 				Method: "POST",
+				Header: http.Header{"Content-Type": {"application/json"}},
+				Body:   ioutil.NopCloser(strings.NewReader(`{"int1":3,"int2":4,"limit":12,"str1":"fizz","str2":"buzz"}`)),
+			},
+			counter:     stats.NewFizzbuzzCounter(),
+			wantCode:    http.StatusOK,
+			wantHeaders: jsonHeaders,
+			wantBody:    []byte(`["1","2","fizz","buzz","5","fizz","7","buzz","fizz","10","11","fizzbuzz"]`),
+		},
+		"OK - GET query string": {
+			req: &http.Request{
+				Method: "GET",
+				URL:    &url.URL{RawQuery: "int1=3&int2=4&limit=12&str1=fizz&str2=buzz"},
+				Body:   http.NoBody,
+			},
+			counter:     stats.NewFizzbuzzCounter(),
+			wantCode:    http.StatusOK,
+			wantHeaders: jsonHeaders,
+			wantBody:    []byte(`["1","2","fizz","buzz","5","fizz","7","buzz","fizz","10","11","fizzbuzz"]`),
+		},
+		"KO - invalid params": {
+			req: &http.Request{
+				Method: "GET",
 				Body: ioutil.NopCloser(strings.NewReader(`{
 					"int1":3,
-					"int2":4,
 					"limit":12,
 					"str1":"fizz",
 					"str2":"buzz"
 				}`)),
 			},
 			counter:     stats.NewFizzbuzzCounter(),
-			wantCode:    http.StatusMethodNotAllowed,
-			wantHeaders: map[string][]string{"Allow": {"GET"}},
-			wantBody:    []byte(`{"code":405,"desc":"method not allowed"}`),
-			wantErrStr:  "invalid method",
+			wantCode:    http.StatusBadRequest,
+			wantHeaders: errorHeaders,
+			wantBody:    []byte(`{"code":400,"desc":"int2 missing (can't be zero)"}`),
+			wantErrStr:  "invalid params",
+		},
+		"KO - limit too high": {
+			handler: Handler{MaxLimit: 10},
+			req: &http.Request{
+				Method: "GET",
+				Body:   ioutil.NopCloser(strings.NewReader(`{"int1":3,"int2":4,"limit":12}`)),
+			},
+			counter:     stats.NewFizzbuzzCounter(),
+			wantCode:    http.StatusBadRequest,
+			wantHeaders: errorHeaders,
+			wantBody:    []byte(`{"code":400,"desc":"limit must be inferior or equal to 10"}`),
+			wantErrStr:  "invalid params",
+		},
+		"KO - body too large": {
+			handler: Handler{MaxBodyBytes: 20},
+			req: &http.Request{
+				Method: "GET",
+				Body:   ioutil.NopCloser(strings.NewReader(`{"int1":3,"int2":4,"limit":12}`)),
+			},
+			counter:     stats.NewFizzbuzzCounter(),
+			wantCode:    http.StatusRequestEntityTooLarge,
+			wantHeaders: errorHeaders,
+			wantBody:    []byte(`{"code":413,"desc":"request body too large (max 20 bytes)"}`),
+			wantErrStr:  "reading body",
+		},
+		"OK - body at max size": {
+			handler: Handler{MaxBodyBytes: 30},
+			req: &http.Request{
+				Method: "GET",
+				Body:   ioutil.NopCloser(strings.NewReader(`{"int1":3,"int2":4,"limit":2}`)),
+			},
+			counter:     stats.NewFizzbuzzCounter(),
+			wantCode:    http.StatusOK,
+			wantHeaders: jsonHeaders,
+			wantBody:    []byte(`["1","2"]`),
+		},
+		"OK - format from the Accept header": {
+			req: &http.Request{
+				Method: "GET",
+				URL:    &url.URL{RawQuery: "int1=3&int2=4&limit=5&str1=fizz&str2=buzz"},
+				Header: http.Header{"Accept": {"text/csv"}},
+				Body:   http.NoBody,
+			},
+			counter:     stats.NewFizzbuzzCounter(),
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"text/csv; charset=utf-8"}, "Vary": {"Accept"}},
+			wantBody:    []byte("1\n2\nfizz\nbuzz\n5\n"),
+		},
+		"OK - format from the query string": {
+			req: &http.Request{
+				Method: "GET",
+				URL:    &url.URL{RawQuery: "int1=3&int2=4&limit=5&str1=fizz&str2=buzz&format=ndjson"},
+				Header: http.Header{"Accept": {"text/csv"}},
+				Body:   http.NoBody,
+			},
+			counter:     stats.NewFizzbuzzCounter(),
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"application/x-ndjson"}, "Vary": {"Accept"}},
+			wantBody:    []byte("\"1\"\n\"2\"\n\"fizz\"\n\"buzz\"\n\"5\"\n"),
+		},
+		"KO - not acceptable": {
+			req: &http.Request{
+				Method: "GET",
+				Header: http.Header{"Accept": {"image/png"}},
+				Body:   ioutil.NopCloser(strings.NewReader(`{"int1":3,"int2":4,"limit":12}`)),
+			},
+			counter:     stats.NewFizzbuzzCounter(),
+			wantCode:    http.StatusNotAcceptable,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}, "Vary": {"Accept"}},
+			wantBody: []byte(`{"code":406,"desc":"accepted types must include one of application/json, ` +
+				`application/x-ndjson, application/ndjson, text/csv, text/plain, application/xml, text/xml"}`),
+			wantErrStr: "negotiating format",
+		},
+		"KO - deadline exceeded": {
+			req: (&http.Request{
+				Method: "GET",
+				Body:   ioutil.NopCloser(strings.NewReader(`{"int1":3,"int2":4,"limit":12}`)),
+			}).WithContext(expired),
+			counter:     stats.NewFizzbuzzCounter(),
+			wantCode:    http.StatusGatewayTimeout,
+			wantHeaders: errorHeaders,
+			wantBody:    []byte(`{"code":504,"desc":"request timeout"}`),
+			wantErrStr:  "error executing fizzbuzz: context deadline exceeded",
+		},
+		"KO - canceled": {
+			req: (&http.Request{
+				Method: "GET",
+				Body:   ioutil.NopCloser(strings.NewReader(`{"int1":3,"int2":4,"limit":12}`)),
+			}).WithContext(canceled),
+			counter:     stats.NewFizzbuzzCounter(),
+			wantCode:    http.StatusServiceUnavailable,
+			wantHeaders: errorHeaders,
+			wantBody:    []byte(`{"code":503,"desc":"request canceled"}`),
+			wantErrStr:  "error executing fizzbuzz: context canceled",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			gotCode, gotHeaders, gotBody, gotErr := tt.handler.ProcessFizzbuzz(tt.req, tt.counter)
+
+			if tt.wantErrStr != "" {
+				assertions.Contains(gotErr.Error(), tt.wantErrStr)
+			} else {
+				assertions.NoError(gotErr)
+			}
+			assertions.Equal(tt.wantCode, gotCode)
+			// the cache headers depend on the params, they are tested apart
+			delete(gotHeaders, "ETag")
+			delete(gotHeaders, "Cache-Control")
+			assertions.Equal(tt.wantHeaders, gotHeaders)
+			assertions.Equal(tt.wantBody, gotBody)
+		})
+	}
+}
+
+func Test_StreamFizzbuzz(t *testing.T) {
+	tests := map[string]struct {
+		req         *http.Request
+		counter     stats.Counter
+		wantCode    int
+		wantHeaders map[string][]string
+		wantBody    []byte
+		wantErrStr  string
+	}{
+		"OK": {
+			req: &http.Request{
+				Method: "GET",
+				Body: ioutil.NopCloser(strings.NewReader(`{
+					"int1":3,
+					"int2":4,
+					"limit":12,
+					"str1":"fi\"zz",
+					"str2":"<buzz>"
+				}`)),
+			},
+			counter:     stats.NewFizzbuzzCounter(),
+			wantCode:    http.StatusOK,
+			wantHeaders: jsonHeaders,
+			wantBody: []byte(`["1","2","fi\"zz","\u003cbuzz\u003e","5","fi\"zz","7","\u003cbuzz\u003e",` +
+				`"fi\"zz","10","11","fi\"zz\u003cbuzz\u003e"]`),
+		},
+		"OK - limit one": {
+			req: &http.Request{
+				Method: "GET",
+				Body:   ioutil.NopCloser(strings.NewReader(`{"int1":3,"int2":4,"limit":1}`)),
+			},
+			counter:     stats.NewFizzbuzzCounter(),
+			wantCode:    http.StatusOK,
+			wantHeaders: jsonHeaders,
+			wantBody:    []byte(`["1"]`),
+		},
+		"OK - XML": {
+			req: &http.Request{
+				Method: "GET",
+				Header: http.Header{"Accept": {"application/xml"}},
+				Body:   ioutil.NopCloser(strings.NewReader(`{"int1":3,"int2":4,"limit":4,"str1":"fizz","str2":"<buzz>"}`)),
+			},
+			counter:     stats.NewFizzbuzzCounter(),
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"application/xml"}, "Vary": {"Accept"}},
+			wantBody: []byte(xml.Header + "<fizzbuzz><element>1</element><element>2</element>" +
+				"<element>fizz</element><element>&lt;buzz&gt;</element></fizzbuzz>"),
 		},
 		"KO - invalid params": {
 			req: &http.Request{
@@ -66,7 +280,7 @@ func Test_ProcessFizzbuzz(t *testing.T) {
 			},
 			counter:     stats.NewFizzbuzzCounter(),
 			wantCode:    http.StatusBadRequest,
-			wantHeaders: map[string][]string{},
+			wantHeaders: errorHeaders,
 			wantBody:    []byte(`{"code":400,"desc":"int2 missing (can't be zero)"}`),
 			wantErrStr:  "invalid params",
 		},
@@ -75,7 +289,7 @@ func Test_ProcessFizzbuzz(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			assertions := assert.New(t)
 
-			gotCode, gotHeaders, gotBody, gotErr := ProcessFizzbuzz(tt.req, tt.counter)
+			gotCode, gotHeaders, gotWriteBody, gotErr := Handler{}.StreamFizzbuzz(tt.req, tt.counter)
 
 			if tt.wantErrStr != "" {
 				assertions.Contains(gotErr.Error(), tt.wantErrStr)
@@ -83,21 +297,161 @@ func Test_ProcessFizzbuzz(t *testing.T) {
 				assertions.NoError(gotErr)
 			}
 			assertions.Equal(tt.wantCode, gotCode)
+			// the cache headers depend on the params, they are tested apart
+			delete(gotHeaders, "ETag")
+			delete(gotHeaders, "Cache-Control")
 			assertions.Equal(tt.wantHeaders, gotHeaders)
-			assertions.Equal(tt.wantBody, gotBody)
+			gotBody := bytes.Buffer{}
+			assertions.NoError(gotWriteBody(&gotBody))
+			assertions.Equal(tt.wantBody, gotBody.Bytes())
 		})
 	}
 }
 
+func Test_StreamFizzbuzz_maxStreamLimit(t *testing.T) {
+	handler := Handler{MaxLimit: 10, MaxStreamLimit: 100}
+	newRequest := func(limit string) *http.Request {
+		return &http.Request{
+			Method: "GET",
+			URL:    &url.URL{RawQuery: "int1=3&int2=5&str1=fizz&str2=buzz&limit=" + limit},
+			Header: http.Header{},
+			Body:   http.NoBody,
+		}
+	}
+
+	tests := map[string]struct {
+		limit    string
+		wantCode int
+		wantBody string
+	}{
+		"OK - beyond the max limit": {limit: "100", wantCode: http.StatusOK},
+		"KO - beyond the max for streams": {
+			limit:    "101",
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"code":400,"desc":"limit must be inferior or equal to 100"}`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			gotCode, _, gotWriteBody, _ := handler.StreamFizzbuzz(newRequest(tt.limit), stats.NewFizzbuzzCounter())
+			assertions.Equal(tt.wantCode, gotCode)
+			gotBody := bytes.Buffer{}
+			assertions.NoError(gotWriteBody(&gotBody))
+			if tt.wantBody != "" {
+				assertions.Equal(tt.wantBody, gotBody.String())
+			}
+		})
+	}
+
+	// the buffered outputs are still bounded by the max limit
+	gotCode, _, _, _ := handler.ProcessFizzbuzz(newRequest("100"), stats.NewFizzbuzzCounter())
+	assert.Equal(t, http.StatusBadRequest, gotCode)
+}
+
+func Test_ProcessFizzbuzz_cache(t *testing.T) {
+	assertions := assert.New(t)
+
+	handler := Handler{CacheMaxAge: time.Hour}
+	counter := stats.NewFizzbuzzCounter()
+	newRequest := func(query string, ifNoneMatch ...string) *http.Request {
+		if !strings.Contains(query, "limit") {
+			query += "&limit=15"
+		}
+		return &http.Request{
+			Method: "GET",
+			URL:    &url.URL{RawQuery: "int1=3&int2=5&str1=fizz&str2=buzz&" + query},
+			Header: http.Header{"If-None-Match": ifNoneMatch},
+			Body:   http.NoBody,
+		}
+	}
+
+	gotCode, gotHeaders, gotBody, gotErr := handler.ProcessFizzbuzz(newRequest(""), counter)
+	assertions.NoError(gotErr)
+	assertions.Equal(http.StatusOK, gotCode)
+	assertions.NotEmpty(gotBody)
+	tag := gotHeaders["ETag"][0]
+	assertions.Regexp(`^"[0-9a-f]{32}"$`, tag)
+	assertions.Equal([]string{"public, max-age=3600"}, gotHeaders["Cache-Control"])
+
+	// the tag only depends on the params and the format
+	_, gotHeaders, _, _ = handler.ProcessFizzbuzz(newRequest(""), counter)
+	assertions.Equal(tag, gotHeaders["ETag"][0])
+	_, gotHeaders, _, _ = Handler{}.StreamFizzbuzz(newRequest(""), counter)
+	assertions.Equal(tag, gotHeaders["ETag"][0], "streamed output tagged differently")
+	_, gotHeaders, _, _ = handler.ProcessFizzbuzz(newRequest("format=csv"), counter)
+	assertions.NotEqual(tag, gotHeaders["ETag"][0], "formats tagged the same")
+	_, gotHeaders, _, _ = handler.ProcessFizzbuzz(newRequest("format=json&limit=16"), counter)
+	assertions.NotEqual(tag, gotHeaders["ETag"][0], "params tagged the same")
+
+	// the private outputs are kept out of the shared caches
+	_, gotHeaders, _, _ = Handler{CacheMaxAge: time.Hour, PrivateCache: true}.ProcessFizzbuzz(newRequest(""), stats.NewFizzbuzzCounter())
+	assertions.Equal([]string{"private, max-age=3600"}, gotHeaders["Cache-Control"])
+	assertions.Equal(tag, gotHeaders["ETag"][0])
+
+	// the client having the output gets a 304 without body
+	for _, ifNoneMatch := range []string{tag, `"fizz", ` + tag, "W/" + tag, "*"} {
+		gotCode, gotHeaders, gotBody, gotErr = handler.ProcessFizzbuzz(newRequest("", ifNoneMatch), counter)
+		assertions.NoError(gotErr)
+		assertions.Equal(http.StatusNotModified, gotCode, ifNoneMatch)
+		assertions.Empty(gotBody)
+		assertions.Equal([]string{tag}, gotHeaders["ETag"])
+	}
+	gotCode, _, gotWriteBody, gotErr := handler.StreamFizzbuzz(newRequest("", tag), counter)
+	assertions.NoError(gotErr)
+	assertions.Equal(http.StatusNotModified, gotCode)
+	gotStreamed := bytes.Buffer{}
+	assertions.NoError(gotWriteBody(&gotStreamed))
+	assertions.Empty(gotStreamed.Bytes())
+	gotCode, _, _, _ = handler.ProcessFizzbuzz(newRequest("", `"fizz"`), counter)
+	assertions.Equal(http.StatusOK, gotCode)
+
+	// but its requests are counted anyway
+	gotCount, gotErr := counter.Get(context.Background(), fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 15, Str1: "fizz", Str2: "buzz"})
+	assertions.NoError(gotErr)
+	assertions.Equal(10, gotCount)
+}
+
+func Test_writeJSONArray_sameAsMarshal(t *testing.T) {
+	params := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 10000, Str1: "fi&zz", Str2: "bu\nzz"}
+
+	output, errExec := fizzbuzz.ExecFizzbuzz(context.Background(), params)
+	assert.NoError(t, errExec)
+	want, errJson := json.Marshal(output)
+	assert.NoError(t, errJson)
+
+	gen, errGen := fizzbuzz.NewGenerator(context.Background(), params)
+	assert.NoError(t, errGen)
+	got := bytes.Buffer{}
+	assert.NoError(t, writeJSONArray(&got, gen))
+
+	assert.Equal(t, want, got.Bytes())
+}
+
+func Test_writeJSONArray_contextDone(t *testing.T) {
+	assertions := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	gen, errGen := fizzbuzz.NewGenerator(ctx, fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16})
+	assertions.NoError(errGen)
+
+	got := bytes.Buffer{}
+	assertions.ErrorIs(writeJSONArray(&got, gen), context.Canceled)
+	assertions.Equal("[", got.String(), "the array is left unterminated so the client knows it is incomplete")
+}
+
 func Test_getParamsFizzbuzz(t *testing.T) {
 	tests := map[string]struct {
-		body          []byte
+		handler       Handler
+		req           *http.Request
 		want          fizzbuzz.Params
 		wantClientErr []string
 		wantErr       []string
 	}{
 		"OK": {
-			body: []byte(`{
+			req: jsonRequest(`{
 				"int1":3,
 				"int2":5,
 				"limit":16,
@@ -112,20 +466,70 @@ func Test_getParamsFizzbuzz(t *testing.T) {
 			},
 		},
 		"KO - missing integers": {
-			body:          []byte(`{}`),
+			req:           jsonRequest(`{}`),
 			wantClientErr: []string{"int1 missing", "int2 missing", "limit missing"},
 			wantErr:       []string{"int1 missing", "int2 missing", "limit missing"},
 		},
 		"KO - limit negative": {
-			body: []byte(`{
+			req: jsonRequest(`{
 				"int1":3,
 				"limit":-16
 			}`),
 			wantClientErr: []string{"int2 missing", "limit must be superior to one"},
 			wantErr:       []string{"int2 missing", "limit must be superior to one"},
 		},
+		"KO - limit too high": {
+			handler: Handler{MaxLimit: 10},
+			req: jsonRequest(`{
+				"int1":3,
+				"int2":5,
+				"limit":16
+			}`),
+			wantClientErr: []string{"limit must be inferior or equal to 10"},
+			wantErr:       []string{"limit must be inferior or equal to 10"},
+		},
+		"OK - query string": {
+			req: &http.Request{
+				Method: "GET",
+				URL:    &url.URL{RawQuery: "int1=3&int2=5&limit=16&str1=fizz&stream=true"},
+			},
+			want: fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz"},
+		},
+		"OK - query string without params uses the body": {
+			req: &http.Request{
+				Method: "GET",
+				URL:    &url.URL{RawQuery: "stream=true"},
+				Body:   ioutil.NopCloser(strings.NewReader(`{"int1":3,"int2":5,"limit":16,"str1":"fizz"}`)),
+			},
+			want: fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz"},
+		},
+		"OK - form": {
+			req: &http.Request{
+				Method: "POST",
+				Header: http.Header{"Content-Type": {"application/x-www-form-urlencoded; charset=utf-8"}},
+				Body:   ioutil.NopCloser(strings.NewReader(`int1=3&int2=5&limit=16&str1=fi%26zz`)),
+			},
+			want: fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fi&zz"},
+		},
+		"KO - query string not integers": {
+			req: &http.Request{
+				Method: "GET",
+				URL:    &url.URL{RawQuery: "int1=a&int2=5&limit=1.5"},
+			},
+			wantClientErr: []string{"int1 must be an integer", "limit must be an integer"},
+			wantErr:       []string{"int1 must be an integer", "limit must be an integer"},
+		},
+		"KO - form missing params": {
+			req: &http.Request{
+				Method: "POST",
+				Header: http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+				Body:   ioutil.NopCloser(strings.NewReader(`int1=3`)),
+			},
+			wantClientErr: []string{"int2 missing", "limit missing"},
+			wantErr:       []string{"int2 missing", "limit missing"},
+		},
 		"KO - invalid JSON": {
-			body:          []byte(`aaa`),
+			req:           jsonRequest(`aaa`),
 			wantClientErr: []string{"invalid params"},
 			wantErr:       []string{"invalid character"},
 		},
@@ -133,7 +537,7 @@ func Test_getParamsFizzbuzz(t *testing.T) {
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			assertions := assert.New(t)
-			got, errClientGot, errGot := getParamsFizzbuzz(tt.body)
+			got, errClientGot, errGot := tt.handler.getParamsFizzbuzz(tt.req)
 			if len(tt.wantErr) != 0 {
 				// test internal error
 				for _, errStr := range tt.wantErr {
@@ -150,3 +554,12 @@ func Test_getParamsFizzbuzz(t *testing.T) {
 		})
 	}
 }
+
+// jsonRequest creates a GET request with this JSON body
+func jsonRequest(body string) *http.Request {
+	return &http.Request{
+		Method: "GET",
+		URL:    &url.URL{},
+		Body:   ioutil.NopCloser(strings.NewReader(body)),
+	}
+}
@@ -0,0 +1,277 @@
+package fizzbuzzhandler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fizzbuzz-server/api/clienterr"
+)
+
+// elements are the elements of a fizzbuzz output, produced one at a time
+// fizzbuzz.Generator is the elements of an output generated on the fly
+type elements interface {
+	Next() (str string, ok bool)
+	Err() error
+}
+
+// sliceElements are the elements of an output already computed
+type sliceElements struct {
+	output []string
+}
+
+func (se *sliceElements) Next() (string, bool) {
+	if len(se.output) == 0 {
+		return "", false
+	}
+	str := se.output[0]
+	se.output = se.output[1:]
+	return str, true
+}
+
+func (se *sliceElements) Err() error {
+	return nil
+}
+
+// format is a representation of the fizzbuzz output the client can ask for
+type format struct {
+	// name is the value of the 'format' query parameter selecting it
+	name string
+	// contentType is the Content-Type header of the response
+	contentType string
+	// mediaTypes are the media types of the Accept header selecting it
+	mediaTypes []string
+	// write writes all the elements, if they are stopped the output is left unterminated and their error is returned
+	write func(io.Writer, elements) error
+}
+
+// formats are the supported formats, by order of preference when the client accepts several
+var formats = []format{
+	{name: "json", contentType: "application/json", mediaTypes: []string{"application/json"}, write: writeJSONArray},
+	{name: "ndjson", contentType: "application/x-ndjson", mediaTypes: []string{"application/x-ndjson", "application/ndjson"}, write: writeNDJSON},
+	{name: "csv", contentType: "text/csv; charset=utf-8", mediaTypes: []string{"text/csv"}, write: writeCSV},
+	{name: "text", contentType: "text/plain; charset=utf-8", mediaTypes: []string{"text/plain"}, write: writeText},
+	{name: "xml", contentType: "application/xml", mediaTypes: []string{"application/xml", "text/xml"}, write: writeXML},
+}
+
+// negotiateFormat selects the format of the response from the 'format' query parameter,
+// or else from the Accept header, JSON being the default
+// it returns two versions of the error if needed, one for the client and one more precise for internal use
+func negotiateFormat(r *http.Request) (format, clienterr.ClientError, error) {
+	if r.URL != nil && r.URL.Query().Has("format") {
+		name := r.URL.Query().Get("format")
+		for _, f := range formats {
+			if f.name == name {
+				return f, clienterr.ClientError{}, nil
+			}
+		}
+		names := make([]string, 0, len(formats))
+		for _, f := range formats {
+			names = append(names, f.name)
+		}
+		return format{},
+			clienterr.ClientError{Code: http.StatusNotAcceptable, Desc: "format must be one of " + strings.Join(names, ", ")},
+			fmt.Errorf("unsupported format %q", name)
+	}
+
+	accept := strings.Join(r.Header.Values("Accept"), ",")
+	if strings.TrimSpace(accept) == "" {
+		return formats[0], clienterr.ClientError{}, nil
+	}
+	ranges := parseAccept(accept)
+	best, bestQuality := -1, 0.0
+	for i, f := range formats {
+		// ties are resolved by the order of preference of the formats
+		if quality := f.quality(ranges); quality > bestQuality {
+			best, bestQuality = i, quality
+		}
+	}
+	if best < 0 {
+		mediaTypes := make([]string, 0, len(formats))
+		for _, f := range formats {
+			mediaTypes = append(mediaTypes, f.mediaTypes...)
+		}
+		return format{},
+			clienterr.ClientError{Code: http.StatusNotAcceptable, Desc: "accepted types must include one of " + strings.Join(mediaTypes, ", ")},
+			fmt.Errorf("unsupported accepted types %q", accept)
+	}
+	return formats[best], clienterr.ClientError{}, nil
+}
+
+// mediaRange is a media range of the Accept header with its quality
+type mediaRange struct {
+	mediaType string
+	quality   float64
+}
+
+// parseAccept parses the media ranges of the Accept header, the malformed ones being ignored
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, errParse := mime.ParseMediaType(strings.TrimSpace(part))
+		if errParse != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			parsed, errQuality := strconv.ParseFloat(q, 64)
+			if errQuality != nil || parsed < 0 || parsed > 1 {
+				continue
+			}
+			quality = parsed
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, quality: quality})
+	}
+	return ranges
+}
+
+// quality gives the quality of the format in these media ranges, zero if it is not accepted
+// the most specific range matching one of its media types applies: type/subtype, then type/*, then */*
+func (f format) quality(ranges []mediaRange) float64 {
+	quality, specificity := 0.0, 0
+	for _, mediaType := range f.mediaTypes {
+		mainType := mediaType[:strings.Index(mediaType, "/")]
+		for _, rg := range ranges {
+			rangeSpecificity := 0
+			switch rg.mediaType {
+			case mediaType:
+				rangeSpecificity = 3
+			case mainType + "/*":
+				rangeSpecificity = 2
+			case "*/*":
+				rangeSpecificity = 1
+			}
+			if rangeSpecificity > specificity || (rangeSpecificity == specificity && rangeSpecificity > 0 && rg.quality > quality) {
+				quality, specificity = rg.quality, rangeSpecificity
+			}
+		}
+	}
+	return quality
+}
+
+// encode gives the whole output in this format
+func (f format) encode(output []string) ([]byte, error) {
+	body := bytes.Buffer{}
+	if errWrite := f.write(&body, &sliceElements{output: output}); errWrite != nil {
+		return nil, errWrite
+	}
+	return body.Bytes(), nil
+}
+
+// writeJSONArray writes the elements as a JSON array of strings, one element at a time
+// if the elements are stopped, the array is left unterminated and their error is returned
+func writeJSONArray(w io.Writer, elems elements) error {
+	if _, errWrite := io.WriteString(w, "["); errWrite != nil {
+		return errWrite
+	}
+	for str, ok := elems.Next(); ok; {
+		elem, errJson := json.Marshal(str)
+		if errJson != nil {
+			return fmt.Errorf("marshalling json: %w", errJson)
+		}
+		if _, errWrite := w.Write(elem); errWrite != nil {
+			return errWrite
+		}
+		if str, ok = elems.Next(); ok {
+			if _, errWrite := io.WriteString(w, ","); errWrite != nil {
+				return errWrite
+			}
+		}
+	}
+	if errGen := elems.Err(); errGen != nil {
+		return fmt.Errorf("generating output: %w", errGen)
+	}
+	_, errWrite := io.WriteString(w, "]")
+	return errWrite
+}
+
+// writeNDJSON writes the elements as JSON strings, one per line
+func writeNDJSON(w io.Writer, elems elements) error {
+	for str, ok := elems.Next(); ok; str, ok = elems.Next() {
+		elem, errJson := json.Marshal(str)
+		if errJson != nil {
+			return fmt.Errorf("marshalling json: %w", errJson)
+		}
+		if _, errWrite := w.Write(append(elem, '\n')); errWrite != nil {
+			return errWrite
+		}
+	}
+	if errGen := elems.Err(); errGen != nil {
+		return fmt.Errorf("generating output: %w", errGen)
+	}
+	return nil
+}
+
+// emptyCSVRecord is the record of an empty element, quoted since a blank line is skipped by the CSV readers
+const emptyCSVRecord = "\"\"\n"
+
+// writeCSV writes the elements as CSV records of a single field, quoted if needed
+func writeCSV(w io.Writer, elems elements) error {
+	csvWriter := csv.NewWriter(w)
+	for str, ok := elems.Next(); ok; str, ok = elems.Next() {
+		if str == "" {
+			// the CSV writer does not quote an empty field, the records it buffered are sent before this one
+			csvWriter.Flush()
+			if errFlush := csvWriter.Error(); errFlush != nil {
+				return errFlush
+			}
+			if _, errWrite := io.WriteString(w, emptyCSVRecord); errWrite != nil {
+				return errWrite
+			}
+			continue
+		}
+		if errWrite := csvWriter.Write([]string{str}); errWrite != nil {
+			return errWrite
+		}
+	}
+	// the records written so far are sent even if the elements are stopped
+	csvWriter.Flush()
+	if errGen := elems.Err(); errGen != nil {
+		return fmt.Errorf("generating output: %w", errGen)
+	}
+	return csvWriter.Error()
+}
+
+// writeText writes the elements as is, one per line
+func writeText(w io.Writer, elems elements) error {
+	for str, ok := elems.Next(); ok; str, ok = elems.Next() {
+		if _, errWrite := io.WriteString(w, str+"\n"); errWrite != nil {
+			return errWrite
+		}
+	}
+	if errGen := elems.Err(); errGen != nil {
+		return fmt.Errorf("generating output: %w", errGen)
+	}
+	return nil
+}
+
+// writeXML writes the elements as an XML document, each one in an 'element' tag of the 'fizzbuzz' root
+// if the elements are stopped, the root is left unclosed and their error is returned
+func writeXML(w io.Writer, elems elements) error {
+	if _, errWrite := io.WriteString(w, xml.Header+"<fizzbuzz>"); errWrite != nil {
+		return errWrite
+	}
+	for str, ok := elems.Next(); ok; str, ok = elems.Next() {
+		if _, errWrite := io.WriteString(w, "<element>"); errWrite != nil {
+			return errWrite
+		}
+		if errWrite := xml.EscapeText(w, []byte(str)); errWrite != nil {
+			return errWrite
+		}
+		if _, errWrite := io.WriteString(w, "</element>"); errWrite != nil {
+			return errWrite
+		}
+	}
+	if errGen := elems.Err(); errGen != nil {
+		return fmt.Errorf("generating output: %w", errGen)
+	}
+	_, errWrite := io.WriteString(w, "</fizzbuzz>")
+	return errWrite
+}
@@ -0,0 +1,185 @@
+package fizzbuzzhandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"fizzbuzz-server/internal/fizzbuzz"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_negotiateFormat(t *testing.T) {
+	tests := map[string]struct {
+		query      string
+		accept     []string
+		wantFormat string
+		wantErrStr string
+	}{
+		"OK - default": {
+			wantFormat: "json",
+		},
+		"OK - query string": {
+			query:      "format=xml",
+			wantFormat: "xml",
+		},
+		"OK - query string over the Accept header": {
+			query:      "format=text",
+			accept:     []string{"application/json"},
+			wantFormat: "text",
+		},
+		"OK - exact type": {
+			accept:     []string{"text/plain"},
+			wantFormat: "text",
+		},
+		"OK - alias": {
+			accept:     []string{"text/xml"},
+			wantFormat: "xml",
+		},
+		"OK - any type": {
+			accept:     []string{"*/*"},
+			wantFormat: "json",
+		},
+		"OK - any subtype, by order of preference": {
+			accept:     []string{"text/*"},
+			wantFormat: "csv",
+		},
+		"OK - highest quality": {
+			accept:     []string{"application/json;q=0.5, application/x-ndjson;q=0.8", "text/csv;q=0.1"},
+			wantFormat: "ndjson",
+		},
+		"OK - most specific range": {
+			accept:     []string{"text/*;q=0.9, text/csv;q=0.1"},
+			wantFormat: "text",
+		},
+		"OK - unsupported types ignored": {
+			accept:     []string{"text/html, application/xhtml+xml, application/xml;q=0.9, */*;q=0.8"},
+			wantFormat: "xml",
+		},
+		"OK - malformed range ignored": {
+			accept:     []string{"text, text/plain;q=fizz, text/csv"},
+			wantFormat: "csv",
+		},
+		"KO - unknown format": {
+			query:      "format=yaml",
+			wantErrStr: `unsupported format "yaml"`,
+		},
+		"KO - unsupported types": {
+			accept:     []string{"text/html, image/*"},
+			wantErrStr: "unsupported accepted types",
+		},
+		"KO - refused type": {
+			accept:     []string{"application/json;q=0"},
+			wantErrStr: "unsupported accepted types",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			r := &http.Request{URL: &url.URL{RawQuery: tt.query}, Header: http.Header{"Accept": tt.accept}}
+			gotFormat, gotClientErr, gotErr := negotiateFormat(r)
+
+			if tt.wantErrStr != "" {
+				assertions.ErrorContains(gotErr, tt.wantErrStr)
+				assertions.Equal(http.StatusNotAcceptable, gotClientErr.Code)
+				return
+			}
+			assertions.NoError(gotErr)
+			assertions.Equal(tt.wantFormat, gotFormat.name)
+		})
+	}
+}
+
+func Test_format_write(t *testing.T) {
+	tests := map[string]struct {
+		format   string
+		output   []string
+		wantBody string
+	}{
+		"OK - json": {
+			format:   "json",
+			output:   []string{"1", `fi"zz`},
+			wantBody: `["1","fi\"zz"]`,
+		},
+		"OK - ndjson": {
+			format:   "ndjson",
+			output:   []string{"1", `fi"zz`},
+			wantBody: "\"1\"\n\"fi\\\"zz\"\n",
+		},
+		"OK - csv": {
+			format:   "csv",
+			output:   []string{"1", "fi,zz", `bu"zz`},
+			wantBody: "1\n\"fi,zz\"\n\"bu\"\"zz\"\n",
+		},
+		"OK - csv with empty elements": {
+			format:   "csv",
+			output:   []string{"1", "", "fizz", ""},
+			wantBody: "1\n\"\"\nfizz\n\"\"\n",
+		},
+		"OK - text": {
+			format:   "text",
+			output:   []string{"1", "fizz"},
+			wantBody: "1\nfizz\n",
+		},
+		"OK - xml": {
+			format:   "xml",
+			output:   []string{"1", "fi&zz"},
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>` + "\n<fizzbuzz><element>1</element><element>fi&amp;zz</element></fizzbuzz>",
+		},
+		"OK - empty": {
+			format:   "text",
+			wantBody: "",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			f, _, errFormat := negotiateFormat(&http.Request{URL: &url.URL{RawQuery: "format=" + tt.format}})
+			assertions.NoError(errFormat)
+			gotBody, gotErr := f.encode(tt.output)
+			assertions.NoError(gotErr)
+			assertions.Equal(tt.wantBody, string(gotBody))
+		})
+	}
+}
+
+func Test_writeCSV_roundTrip(t *testing.T) {
+	assertions := assert.New(t)
+
+	// the empty words give empty elements, which must be read back as records
+	params := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 15, Str1: "", Str2: ""}
+	want, errExec := fizzbuzz.ExecFizzbuzz(context.Background(), params)
+	require.NoError(t, errExec)
+	gen, errGen := fizzbuzz.NewGenerator(context.Background(), params)
+	require.NoError(t, errGen)
+	body := &bytes.Buffer{}
+	assertions.NoError(writeCSV(body, gen))
+
+	records, errRead := csv.NewReader(body).ReadAll()
+	assertions.NoError(errRead)
+	got := make([]string, 0, len(records))
+	for _, record := range records {
+		got = append(got, record...)
+	}
+	assertions.Equal(want, got)
+}
+
+func Test_format_write_contextDone(t *testing.T) {
+	for _, f := range formats {
+		t.Run(f.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			gen, errGen := fizzbuzz.NewGenerator(ctx, fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16})
+			assert.NoError(t, errGen)
+
+			assert.ErrorIs(t, f.write(&bytes.Buffer{}, gen), context.Canceled)
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package fizzbuzzhandler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fizzbuzz-server/api/clienterr"
+	"fizzbuzz-server/internal/fizzbuzz"
+	"fizzbuzz-server/internal/stats"
+)
+
+// maxRules is the greatest number of rules accepted in a generalized fizzbuzz request
+const maxRules = 100
+
+// requestV2 is the body of a generalized fizzbuzz request
+// offset and count are optional, they restrict the response to a page of the output
+type requestV2 struct {
+	fizzbuzz.RuleSet
+	Offset *int `json:"offset"`
+	Count  *int `json:"count"`
+}
+
+// ProcessFizzbuzzV2 does all the process of a generalized fizzbuzz request
+func (h Handler) ProcessFizzbuzzV2(r *http.Request, counter stats.Counter) (int, map[string][]string, []byte, error) {
+	ruleSet, page, f, code, headers, errBody, errPrepare := h.prepareFizzbuzzV2(r, counter)
+	if errPrepare != nil {
+		return code, headers, errBody, errPrepare
+	}
+
+	// execute fizzbuzz
+	output, errExec := fizzbuzz.ExecPage(r.Context(), ruleSet, page)
+	if errExec != nil {
+		clientErr := execClientError(errExec)
+		return clientErr.Code,
+			clienterr.Headers(),
+			clientErr.GetErrorBody(r.Context()),
+			fmt.Errorf("error executing fizzbuzz: %w", errExec)
+	}
+
+	// create response
+	body, errEncode := f.encode(output)
+	if errEncode != nil {
+		return http.StatusInternalServerError,
+			clienterr.Headers(),
+			clienterr.InternalError.GetErrorBody(r.Context()),
+			fmt.Errorf("error encoding output: %w", errEncode)
+	}
+	return http.StatusOK,
+		headers,
+		body,
+		nil
+}
+
+// StreamFizzbuzzV2 does all the process of a generalized fizzbuzz request, like ProcessFizzbuzzV2,
+// but the output is generated while the body is written
+func (h Handler) StreamFizzbuzzV2(r *http.Request, counter stats.Counter) (int, map[string][]string, func(io.Writer) error, error) {
+	// the receiver is a copy, the streamed pages are only bounded by their own maximum
+	h.MaxLimit = h.MaxStreamLimit
+	ruleSet, page, f, code, headers, errBody, errPrepare := h.prepareFizzbuzzV2(r, counter)
+	if errPrepare != nil {
+		return code, headers, writeBytes(errBody), errPrepare
+	}
+
+	// create generator
+	gen, errGen := fizzbuzz.NewPageGenerator(r.Context(), ruleSet, page)
+	if errGen != nil {
+		return http.StatusInternalServerError,
+			clienterr.Headers(),
+			writeBytes(clienterr.InternalError.GetErrorBody(r.Context())),
+			fmt.Errorf("error creating fizzbuzz generator: %w", errGen)
+	}
+
+	return http.StatusOK,
+		headers,
+		func(w io.Writer) error { return f.write(w, gen) },
+		nil
+}
+
+// prepareFizzbuzzV2 negotiates the format of the response, retrieves and checks the rule set and page
+// of the request then counts it
+// it returns the status code and headers of the response, with the pagination metadata,
+// and in case of error its body
+func (h Handler) prepareFizzbuzzV2(r *http.Request, counter stats.Counter) (fizzbuzz.RuleSet, fizzbuzz.Page, format, int, map[string][]string, []byte, error) {
+	// negotiate format
+	f, clientErr, errFormat := negotiateFormat(r)
+	if errFormat != nil {
+		return fizzbuzz.RuleSet{},
+			fizzbuzz.Page{},
+			format{},
+			clientErr.Code,
+			negotiationErrorHeaders(),
+			clientErr.GetErrorBody(r.Context()),
+			fmt.Errorf("negotiating format: %w", errFormat)
+	}
+
+	// retrieve and check rule set
+	ruleSet, page, clientErr, errRuleSet := h.getRuleSet(r)
+	if errRuleSet != nil {
+		return fizzbuzz.RuleSet{},
+			fizzbuzz.Page{},
+			format{},
+			clientErr.Code,
+			clienterr.Headers(),
+			clientErr.GetErrorBody(r.Context()),
+			fmt.Errorf("invalid params: %w", errRuleSet)
+	}
+
+	// increment counter, the page telling the counter how many elements are computed
+	if errInc := counter.IncRules(stats.NewPageContext(r.Context(), page), ruleSet); errInc != nil {
+		return fizzbuzz.RuleSet{},
+			fizzbuzz.Page{},
+			format{},
+			http.StatusInternalServerError,
+			clienterr.Headers(),
+			clienterr.InternalError.GetErrorBody(r.Context()),
+			fmt.Errorf("error incrementing counter: %w", errInc)
+	}
+
+	headers := formatHeaders(f)
+	for key, values := range pageHeaders(ruleSet.Limit, page) {
+		headers[key] = values
+	}
+	return ruleSet, page, f, http.StatusOK, headers, nil, nil
+}
+
+// pageHeaders gives the pagination metadata of the response: the total number of elements
+// and, if there are elements after this page, the cursor to send as offset to retrieve the next one
+func pageHeaders(limit int, page fizzbuzz.Page) map[string][]string {
+	headers := map[string][]string{"X-Total-Count": {strconv.Itoa(limit)}}
+	if next, ok := page.Next(limit); ok {
+		headers["X-Next-Cursor"] = []string{strconv.Itoa(next.Offset)}
+	}
+	return headers
+}
+
+// getRuleSet retrieves and checks the rule set and the page from the JSON body of the request
+// without offset nor count, the page is the whole output
+// it returns two versions of the error if needed, one for the client and one more precise for internal use
+func (h Handler) getRuleSet(r *http.Request) (fizzbuzz.RuleSet, fizzbuzz.Page, clienterr.ClientError, error) {
+	body, clientErr, errRead := h.readBody(r)
+	if errRead != nil {
+		return fizzbuzz.RuleSet{}, fizzbuzz.Page{}, clientErr, fmt.Errorf("reading body: %w", errRead)
+	}
+
+	req := requestV2{}
+	errJson := json.Unmarshal(body, &req)
+	if errJson != nil {
+		return fizzbuzz.RuleSet{},
+			fizzbuzz.Page{},
+			clienterr.ClientError{Code: http.StatusBadRequest, Desc: "invalid params"},
+			fmt.Errorf("unmarshalling json: %w", errJson)
+	}
+	return h.checkRuleSet(req)
+}
+
+// checkRuleSet checks the rule set and the page are valid for the generalized fizzbuzz process
+// the maximum limit bounds the number of elements computed, so it applies to the page when there is one
+// it returns two versions of the error if needed, one for the client and one more precise for internal use
+func (h Handler) checkRuleSet(req requestV2) (fizzbuzz.RuleSet, fizzbuzz.Page, clienterr.ClientError, error) {
+	ruleSet := req.RuleSet
+	paginated := req.Offset != nil || req.Count != nil
+	page := fizzbuzz.Page{Offset: 0, Count: ruleSet.Limit}
+	if req.Offset != nil {
+		page.Offset = *req.Offset
+		page.Count = ruleSet.Limit - page.Offset
+		if page.Count < 1 {
+			// the page is after the limit, so empty whatever its count
+			page.Count = 1
+		}
+	}
+	if req.Count != nil {
+		page.Count = *req.Count
+	}
+
+	strBuilder := strings.Builder{}
+	if len(ruleSet.Rules) == 0 {
+		strBuilder.WriteString("rules missing (at least one rule), ")
+	} else if len(ruleSet.Rules) > maxRules {
+		strBuilder.WriteString(fmt.Sprintf("too many rules (max %d), ", maxRules))
+	} else {
+		for i, rule := range ruleSet.Rules {
+			if rule.Divisor == 0 {
+				strBuilder.WriteString(fmt.Sprintf("rule %d: divisor missing (can't be zero), ", i+1))
+			}
+		}
+	}
+	if ruleSet.Limit == 0 {
+		strBuilder.WriteString("limit missing (can't be inferior to one), ")
+	} else if ruleSet.Limit < 0 {
+		strBuilder.WriteString("limit must be superior to one, ")
+	} else if !paginated && h.MaxLimit > 0 && ruleSet.Limit > h.MaxLimit {
+		strBuilder.WriteString(fmt.Sprintf("limit must be inferior or equal to %d, ", h.MaxLimit))
+	}
+	if page.Offset < 0 {
+		strBuilder.WriteString("offset must be positive, ")
+	}
+	if req.Count != nil && page.Count < 1 {
+		strBuilder.WriteString("count must be superior to one, ")
+	} else if paginated && h.MaxLimit > 0 && page.Count > h.MaxLimit && ruleSet.Limit-page.Offset > h.MaxLimit {
+		strBuilder.WriteString(fmt.Sprintf("count must be inferior or equal to %d, ", h.MaxLimit))
+	}
+	if errStr := strBuilder.String(); errStr != "" {
+		// remove trailing comma and space
+		errStr = errStr[:len(errStr)-2]
+		return ruleSet, page, clienterr.ClientError{Code: http.StatusBadRequest, Desc: errStr}, errors.New(errStr)
+	}
+
+	return ruleSet, page, clienterr.ClientError{}, nil
+}
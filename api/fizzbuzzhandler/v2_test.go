@@ -0,0 +1,203 @@
+package fizzbuzzhandler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"fizzbuzz-server/internal/fizzbuzz"
+	"fizzbuzz-server/internal/stats"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ProcessFizzbuzzV2(t *testing.T) {
+	tests := map[string]struct {
+		handler     Handler
+		req         *http.Request
+		wantCode    int
+		wantHeaders map[string][]string
+		wantBody    []byte
+		wantErrStr  string
+		wantCounted *fizzbuzz.RuleSet
+	}{
+		"OK": {
+			req:         jsonRequest(`{"rules":[{"divisor":3,"word":"fizz"},{"divisor":5,"word":"buzz"},{"divisor":7,"word":"bazz"}],"limit":21}`),
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}, "Vary": {"Accept"}, "X-Total-Count": {"21"}},
+			wantBody: []byte(`["1","2","fizz","4","buzz","fizz","bazz","8","fizz","buzz","11",` +
+				`"fizz","13","bazz","fizzbuzz","16","17","fizz","19","buzz","fizzbazz"]`),
+			wantCounted: &fizzbuzz.RuleSet{
+				Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}, {Divisor: 5, Word: "buzz"}, {Divisor: 7, Word: "bazz"}},
+				Limit: 21,
+			},
+		},
+		"OK - words concatenated in the order of the rules": {
+			req:         jsonRequest(`{"rules":[{"divisor":5,"word":"buzz"},{"divisor":3,"word":"fizz"}],"limit":15}`),
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}, "Vary": {"Accept"}, "X-Total-Count": {"15"}},
+			wantBody:    []byte(`["1","2","fizz","4","buzz","fizz","7","8","fizz","buzz","11","fizz","13","14","buzzfizz"]`),
+			wantCounted: &fizzbuzz.RuleSet{
+				Rules: []fizzbuzz.Rule{{Divisor: 5, Word: "buzz"}, {Divisor: 3, Word: "fizz"}},
+				Limit: 15,
+			},
+		},
+		"OK - first page": {
+			req:         jsonRequest(`{"rules":[{"divisor":3,"word":"fizz"},{"divisor":5,"word":"buzz"}],"limit":16,"count":5}`),
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}, "Vary": {"Accept"}, "X-Total-Count": {"16"}, "X-Next-Cursor": {"5"}},
+			wantBody:    []byte(`["1","2","fizz","4","buzz"]`),
+			wantCounted: &fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}, {Divisor: 5, Word: "buzz"}}, Limit: 16},
+		},
+		"OK - last page": {
+			req:         jsonRequest(`{"rules":[{"divisor":3,"word":"fizz"},{"divisor":5,"word":"buzz"}],"limit":16,"offset":10,"count":10}`),
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}, "Vary": {"Accept"}, "X-Total-Count": {"16"}},
+			wantBody:    []byte(`["11","fizz","13","14","fizzbuzz","16"]`),
+			wantCounted: &fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}, {Divisor: 5, Word: "buzz"}}, Limit: 16},
+		},
+		"OK - offset after the limit": {
+			req:         jsonRequest(`{"rules":[{"divisor":3,"word":"fizz"}],"limit":16,"offset":20}`),
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}, "Vary": {"Accept"}, "X-Total-Count": {"16"}},
+			wantBody:    []byte(`[]`),
+			wantCounted: &fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}}, Limit: 16},
+		},
+		"OK - page beyond the max limit": {
+			handler:     Handler{MaxLimit: 10},
+			req:         jsonRequest(`{"rules":[{"divisor":3,"word":"fizz"}],"limit":2000000000,"offset":1000000000,"count":3}`),
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}, "Vary": {"Accept"}, "X-Total-Count": {"2000000000"}, "X-Next-Cursor": {"1000000003"}},
+			wantBody:    []byte(`["1000000001","fizz","1000000003"]`),
+			wantCounted: &fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}}, Limit: 2000000000},
+		},
+		"KO - invalid page": {
+			req:         jsonRequest(`{"rules":[{"divisor":3,"word":"fizz"}],"limit":16,"offset":-1,"count":0}`),
+			wantCode:    http.StatusBadRequest,
+			wantHeaders: errorHeaders,
+			wantBody:    []byte(`{"code":400,"desc":"offset must be positive, count must be superior to one"}`),
+			wantErrStr:  "invalid params",
+		},
+		"KO - count too high": {
+			handler:     Handler{MaxLimit: 10},
+			req:         jsonRequest(`{"rules":[{"divisor":3,"word":"fizz"}],"limit":100,"offset":50}`),
+			wantCode:    http.StatusBadRequest,
+			wantHeaders: errorHeaders,
+			wantBody:    []byte(`{"code":400,"desc":"count must be inferior or equal to 10"}`),
+			wantErrStr:  "invalid params",
+		},
+		"KO - invalid json": {
+			req:         jsonRequest(`{"rules":3}`),
+			wantCode:    http.StatusBadRequest,
+			wantHeaders: errorHeaders,
+			wantBody:    []byte(`{"code":400,"desc":"invalid params"}`),
+			wantErrStr:  "invalid params",
+		},
+		"KO - no rules": {
+			req:         jsonRequest(`{"rules":[],"limit":12}`),
+			wantCode:    http.StatusBadRequest,
+			wantHeaders: errorHeaders,
+			wantBody:    []byte(`{"code":400,"desc":"rules missing (at least one rule)"}`),
+			wantErrStr:  "invalid params",
+		},
+		"KO - too many rules": {
+			req:         jsonRequest(`{"rules":[` + strings.Repeat(`{"divisor":2,"word":"a"},`, maxRules) + `{"divisor":3,"word":"b"}],"limit":12}`),
+			wantCode:    http.StatusBadRequest,
+			wantHeaders: errorHeaders,
+			wantBody:    []byte(`{"code":400,"desc":"too many rules (max 100)"}`),
+			wantErrStr:  "invalid params",
+		},
+		"KO - invalid rules and limit": {
+			req:         jsonRequest(`{"rules":[{"divisor":3,"word":"fizz"},{"word":"buzz"},{"divisor":0}],"limit":-1}`),
+			wantCode:    http.StatusBadRequest,
+			wantHeaders: errorHeaders,
+			wantBody: []byte(`{"code":400,"desc":"rule 2: divisor missing (can't be zero), ` +
+				`rule 3: divisor missing (can't be zero), limit must be superior to one"}`),
+			wantErrStr: "invalid params",
+		},
+		"KO - limit too high": {
+			handler:     Handler{MaxLimit: 10},
+			req:         jsonRequest(`{"rules":[{"divisor":3,"word":"fizz"}],"limit":12}`),
+			wantCode:    http.StatusBadRequest,
+			wantHeaders: errorHeaders,
+			wantBody:    []byte(`{"code":400,"desc":"limit must be inferior or equal to 10"}`),
+			wantErrStr:  "invalid params",
+		},
+		"KO - body too large": {
+			handler:     Handler{MaxBodyBytes: 10},
+			req:         jsonRequest(`{"rules":[{"divisor":3,"word":"fizz"}],"limit":12}`),
+			wantCode:    http.StatusRequestEntityTooLarge,
+			wantHeaders: errorHeaders,
+			wantBody:    []byte(`{"code":413,"desc":"request body too large (max 10 bytes)"}`),
+			wantErrStr:  "invalid params",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			counter := stats.NewFizzbuzzCounter()
+			gotCode, gotHeaders, gotBody, gotErr := tt.handler.ProcessFizzbuzzV2(tt.req, counter)
+
+			if tt.wantErrStr != "" {
+				assertions.Contains(gotErr.Error(), tt.wantErrStr)
+			} else {
+				assertions.NoError(gotErr)
+			}
+			assertions.Equal(tt.wantCode, gotCode)
+			assertions.Equal(tt.wantHeaders, gotHeaders)
+			assertions.Equal(tt.wantBody, gotBody)
+
+			gotMostFreqRules, _ := counter.MostFrequentRules(context.Background())
+			if tt.wantCounted != nil {
+				assertions.Equal(stats.MostFrequentRules{Count: 1, RuleSets: []fizzbuzz.RuleSet{*tt.wantCounted}}, gotMostFreqRules)
+			} else {
+				assertions.Equal(0, gotMostFreqRules.Count, "invalid request counted")
+			}
+		})
+	}
+}
+
+func Test_StreamFizzbuzzV2(t *testing.T) {
+	tests := map[string]struct {
+		req         *http.Request
+		wantCode    int
+		wantHeaders map[string][]string
+		wantBody    []byte
+		wantErrStr  string
+	}{
+		"OK": {
+			req:         jsonRequest(`{"rules":[{"divisor":2,"word":"fizz"},{"divisor":3,"word":"buzz"},{"divisor":4,"word":"bazz"}],"limit":12}`),
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}, "Vary": {"Accept"}, "X-Total-Count": {"12"}},
+			wantBody:    []byte(`["1","fizz","buzz","fizzbazz","5","fizzbuzz","7","fizzbazz","buzz","fizz","11","fizzbuzzbazz"]`),
+		},
+		"KO - invalid params": {
+			req:         jsonRequest(`{"rules":[{"divisor":3,"word":"fizz"}]}`),
+			wantCode:    http.StatusBadRequest,
+			wantHeaders: errorHeaders,
+			wantBody:    []byte(`{"code":400,"desc":"limit missing (can't be inferior to one)"}`),
+			wantErrStr:  "invalid params",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			gotCode, gotHeaders, gotWriteBody, gotErr := Handler{}.StreamFizzbuzzV2(tt.req, stats.NewFizzbuzzCounter())
+
+			if tt.wantErrStr != "" {
+				assertions.Contains(gotErr.Error(), tt.wantErrStr)
+			} else {
+				assertions.NoError(gotErr)
+			}
+			assertions.Equal(tt.wantCode, gotCode)
+			assertions.Equal(tt.wantHeaders, gotHeaders)
+			gotBody := bytes.Buffer{}
+			assertions.NoError(gotWriteBody(&gotBody))
+			assertions.Equal(tt.wantBody, gotBody.Bytes())
+		})
+	}
+}
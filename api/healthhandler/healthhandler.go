@@ -0,0 +1,58 @@
+package healthhandler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"fizzbuzz-server/api/clienterr"
+	"fizzbuzz-server/internal/stats"
+)
+
+// okBody is the body of the probes succeeding
+var okBody = []byte(`{"status":"ok"}`)
+
+// ProcessHealthz does all the process of a liveness probe, the server is alive as long as it answers
+func ProcessHealthz(_ *http.Request, _ stats.Counter) (int, map[string][]string, []byte, error) {
+	return http.StatusOK,
+		map[string][]string{"Content-Type": {"application/json"}},
+		okBody,
+		nil
+}
+
+// Readiness tells if the server is ready to receive traffic
+// the zero value is ready, until Drain is called
+type Readiness struct {
+	draining int32
+}
+
+// Drain makes the readiness probe fail from now on, so the load balancers stop sending traffic before the shutdown
+func (rd *Readiness) Drain() {
+	atomic.StoreInt32(&rd.draining, 1)
+}
+
+// ProcessReadyz does all the process of a readiness probe
+// the server is not ready once draining or if the backend of the counter is unreachable
+func (rd *Readiness) ProcessReadyz(r *http.Request, counter stats.Counter) (int, map[string][]string, []byte, error) {
+	// check draining
+	if atomic.LoadInt32(&rd.draining) == 1 {
+		return http.StatusServiceUnavailable,
+			clienterr.Headers(),
+			clienterr.ClientError{Code: http.StatusServiceUnavailable, Desc: "server draining"}.GetErrorBody(r.Context()),
+			errors.New("server draining")
+	}
+
+	// check counter backend
+	if errPing := counter.Ping(r.Context()); errPing != nil {
+		return http.StatusServiceUnavailable,
+			clienterr.Headers(),
+			clienterr.ClientError{Code: http.StatusServiceUnavailable, Desc: "counter unavailable"}.GetErrorBody(r.Context()),
+			fmt.Errorf("error pinging counter: %w", errPing)
+	}
+
+	return http.StatusOK,
+		map[string][]string{"Content-Type": {"application/json"}},
+		okBody,
+		nil
+}
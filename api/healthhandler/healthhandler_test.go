@@ -0,0 +1,111 @@
+package healthhandler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"fizzbuzz-server/internal/stats"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ProcessHealthz(t *testing.T) {
+	tests := map[string]struct {
+		req         *http.Request
+		wantCode    int
+		wantHeaders map[string][]string
+		wantBody    []byte
+		wantErrStr  string
+	}{
+		"OK": {
+			req:         &http.Request{Method: "GET"},
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}},
+			wantBody:    []byte(`{"status":"ok"}`),
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			gotCode, gotHeaders, gotBody, gotErr := ProcessHealthz(tt.req, stats.NewFizzbuzzCounter())
+
+			if tt.wantErrStr != "" {
+				assertions.Contains(gotErr.Error(), tt.wantErrStr)
+			} else {
+				assertions.NoError(gotErr)
+			}
+			assertions.Equal(tt.wantCode, gotCode)
+			assertions.Equal(tt.wantHeaders, gotHeaders)
+			assertions.Equal(tt.wantBody, gotBody)
+		})
+	}
+}
+
+func Test_Readiness_ProcessReadyz(t *testing.T) {
+	tests := map[string]struct {
+		req         *http.Request
+		counter     stats.Counter
+		draining    bool
+		wantCode    int
+		wantHeaders map[string][]string
+		wantBody    []byte
+		wantErrStr  string
+	}{
+		"OK": {
+			req:         &http.Request{Method: "GET"},
+			counter:     stats.NewFizzbuzzCounter(),
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}},
+			wantBody:    []byte(`{"status":"ok"}`),
+		},
+		"KO - draining": {
+			req:         &http.Request{Method: "GET"},
+			counter:     stats.NewFizzbuzzCounter(),
+			draining:    true,
+			wantCode:    http.StatusServiceUnavailable,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}},
+			wantBody:    []byte(`{"code":503,"desc":"server draining"}`),
+			wantErrStr:  "server draining",
+		},
+		"KO - counter unavailable": {
+			req:         &http.Request{Method: "GET"},
+			counter:     unreachableCounter{stats.NewFizzbuzzCounter()},
+			wantCode:    http.StatusServiceUnavailable,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}},
+			wantBody:    []byte(`{"code":503,"desc":"counter unavailable"}`),
+			wantErrStr:  "backend unreachable",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			readiness := &Readiness{}
+			if tt.draining {
+				readiness.Drain()
+			}
+			gotCode, gotHeaders, gotBody, gotErr := readiness.ProcessReadyz(tt.req, tt.counter)
+
+			if tt.wantErrStr != "" {
+				assertions.Contains(gotErr.Error(), tt.wantErrStr)
+			} else {
+				assertions.NoError(gotErr)
+			}
+			assertions.Equal(tt.wantCode, gotCode)
+			assertions.Equal(tt.wantHeaders, gotHeaders)
+			assertions.Equal(tt.wantBody, gotBody)
+		})
+	}
+}
+
+// unreachableCounter is a counter whose backend can't be reached
+type unreachableCounter struct {
+	stats.Counter
+}
+
+func (uc unreachableCounter) Ping(_ context.Context) error {
+	return errors.New("backend unreachable")
+}
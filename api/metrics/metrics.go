@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fizzbuzz-server/internal/fizzbuzz"
+	"fizzbuzz-server/internal/lrucache"
+	"fizzbuzz-server/internal/stats"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "fizzbuzz"
+
+// Metrics collects the metrics of the API in its own registry, exposed in the Prometheus text format
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	limits   *prometheus.HistogramVec
+	panics   *prometheus.CounterVec
+	aborts   *prometheus.CounterVec
+}
+
+// New creates the metrics of the API, including the cardinality of this counter
+func New(counter stats.Counter) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests handled, by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of the HTTP requests, by route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route"}),
+		limits: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "limit",
+			Help:      "Limit of the valid fizzbuzz requests, by version of the endpoint.",
+			Buckets:   prometheus.ExponentialBuckets(1, 10, 10),
+		}, []string{"version"}),
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_panics_total",
+			Help:      "Number of panics recovered while handling HTTP requests, by route.",
+		}, []string{"route"}),
+		aborts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_aborted_total",
+			Help:      "Number of HTTP requests whose response was aborted, by route.",
+		}, []string{"route"}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.limits,
+		m.panics,
+		m.aborts,
+		newCardinalityCollector(counter),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a request handled by this route
+func (m *Metrics) ObserveRequest(route, method string, code int, duration time.Duration) {
+	m.requests.WithLabelValues(route, method, strconv.Itoa(code)).Inc()
+	m.duration.WithLabelValues(route).Observe(duration.Seconds())
+}
+
+// ObservePanic records a panic recovered while handling a request of this route
+func (m *Metrics) ObservePanic(route string) {
+	m.panics.WithLabelValues(route).Inc()
+}
+
+// ObserveAbort records a request of this route whose response was aborted, the connection being closed
+func (m *Metrics) ObserveAbort(route string) {
+	m.aborts.WithLabelValues(route).Inc()
+}
+
+// Counter wraps the counter so the limit of each counted request is recorded
+func (m *Metrics) Counter(counter stats.Counter) stats.Counter {
+	return observedCounter{Counter: counter, limits: m.limits}
+}
+
+// observedCounter is a counter recording the limit of the requests it counts
+type observedCounter struct {
+	stats.Counter
+	limits *prometheus.HistogramVec
+}
+
+func (oc observedCounter) Inc(ctx context.Context, params fizzbuzz.Params) error {
+	if errInc := oc.Counter.Inc(ctx, params); errInc != nil {
+		return errInc
+	}
+	oc.limits.WithLabelValues("v1").Observe(float64(params.Limit))
+	return nil
+}
+
+func (oc observedCounter) IncRules(ctx context.Context, ruleSet fizzbuzz.RuleSet) error {
+	if errInc := oc.Counter.IncRules(ctx, ruleSet); errInc != nil {
+		return errInc
+	}
+	oc.limits.WithLabelValues("v2").Observe(float64(ruleSet.Limit))
+	return nil
+}
+
+// cardinalityCollector retrieves the cardinality of the counter on each scrape
+type cardinalityCollector struct {
+	counter stats.Counter
+	desc    *prometheus.Desc
+}
+
+func newCardinalityCollector(counter stats.Counter) cardinalityCollector {
+	return cardinalityCollector{
+		counter: counter,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "counter", "cardinality"),
+			"Number of distinct parameters (kind params) and rule sets (kind rules) counted.",
+			[]string{"kind"},
+			nil,
+		),
+	}
+}
+
+func (cc cardinalityCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cc.desc
+}
+
+func (cc cardinalityCollector) Collect(ch chan<- prometheus.Metric) {
+	cardinality, errCard := cc.counter.Cardinality(context.Background())
+	if errCard != nil {
+		ch <- prometheus.NewInvalidMetric(cc.desc, errCard)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(cc.desc, prometheus.GaugeValue, float64(cardinality.Params), "params")
+	ch <- prometheus.MustNewConstMetric(cc.desc, prometheus.GaugeValue, float64(cardinality.Rules), "rules")
+}
+
+// ObserveCache records the use of the response cache
+func (m *Metrics) ObserveCache(cache *lrucache.Cache) {
+	m.registry.MustRegister(newCacheCollector(cache))
+}
+
+// cacheCollector retrieves the statistics of the response cache on each scrape
+type cacheCollector struct {
+	cache                                                       *lrucache.Cache
+	hitsDesc, missesDesc, evictionsDesc, bytesDesc, entriesDesc *prometheus.Desc
+}
+
+func newCacheCollector(cache *lrucache.Cache) cacheCollector {
+	newDesc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "response_cache", name), help, nil, nil)
+	}
+	return cacheCollector{
+		cache:         cache,
+		hitsDesc:      newDesc("hits_total", "Number of fizzbuzz responses found in the cache."),
+		missesDesc:    newDesc("misses_total", "Number of fizzbuzz responses not found in the cache."),
+		evictionsDesc: newDesc("evictions_total", "Number of fizzbuzz responses evicted from the cache to make room."),
+		bytesDesc:     newDesc("bytes", "Total size in bytes of the cached fizzbuzz responses."),
+		entriesDesc:   newDesc("entries", "Number of cached fizzbuzz responses."),
+	}
+}
+
+func (cc cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cc.hitsDesc
+	ch <- cc.missesDesc
+	ch <- cc.evictionsDesc
+	ch <- cc.bytesDesc
+	ch <- cc.entriesDesc
+}
+
+func (cc cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := cc.cache.Stats()
+	ch <- prometheus.MustNewConstMetric(cc.hitsDesc, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(cc.missesDesc, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(cc.evictionsDesc, prometheus.CounterValue, float64(stats.Evictions))
+	ch <- prometheus.MustNewConstMetric(cc.bytesDesc, prometheus.GaugeValue, float64(stats.Bytes))
+	ch <- prometheus.MustNewConstMetric(cc.entriesDesc, prometheus.GaugeValue, float64(stats.Entries))
+}
@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fizzbuzz-server/internal/fizzbuzz"
+	"fizzbuzz-server/internal/lrucache"
+	"fizzbuzz-server/internal/stats"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Metrics_ObserveRequest(t *testing.T) {
+	assertions := assert.New(t)
+
+	m := New(stats.NewFizzbuzzCounter())
+	m.ObserveRequest("/fizzbuzz", "GET", http.StatusOK, 10*time.Millisecond)
+	m.ObserveRequest("/fizzbuzz", "GET", http.StatusOK, 20*time.Millisecond)
+	m.ObserveRequest("/fizzbuzz", "GET", http.StatusBadRequest, time.Millisecond)
+	m.ObserveRequest("/mostfreqreq", "GET", http.StatusOK, time.Millisecond)
+
+	assertions.Equal(2.0, testutil.ToFloat64(m.requests.WithLabelValues("/fizzbuzz", "GET", "200")))
+	assertions.Equal(1.0, testutil.ToFloat64(m.requests.WithLabelValues("/fizzbuzz", "GET", "400")))
+	assertions.Equal(1.0, testutil.ToFloat64(m.requests.WithLabelValues("/mostfreqreq", "GET", "200")))
+	assertions.Equal(2, testutil.CollectAndCount(m.duration), "one histogram per route")
+}
+
+func Test_Metrics_ObservePanic(t *testing.T) {
+	assertions := assert.New(t)
+
+	m := New(stats.NewFizzbuzzCounter())
+	m.ObservePanic("/fizzbuzz")
+	m.ObservePanic("/fizzbuzz")
+	m.ObservePanic("/topreq")
+
+	assertions.Equal(2.0, testutil.ToFloat64(m.panics.WithLabelValues("/fizzbuzz")))
+	assertions.Equal(1.0, testutil.ToFloat64(m.panics.WithLabelValues("/topreq")))
+}
+
+func Test_Metrics_Counter(t *testing.T) {
+	assertions := assert.New(t)
+
+	counter := stats.NewFizzbuzzCounter()
+	m := New(counter)
+	observed := m.Counter(counter)
+
+	params := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	assertions.NoError(observed.Inc(context.Background(), params))
+	assertions.NoError(observed.Inc(context.Background(), params))
+	assertions.NoError(observed.IncRules(context.Background(), fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}}, Limit: 2000}))
+
+	// the requests are still counted by the wrapped counter
+	gotCount, gotErr := counter.Get(context.Background(), params)
+	assertions.NoError(gotErr)
+	assertions.Equal(2, gotCount)
+
+	errCompare := testutil.CollectAndCompare(m.limits, strings.NewReader(`
+# HELP fizzbuzz_limit Limit of the valid fizzbuzz requests, by version of the endpoint.
+# TYPE fizzbuzz_limit histogram
+fizzbuzz_limit_bucket{version="v1",le="1"} 0
+fizzbuzz_limit_bucket{version="v1",le="10"} 0
+fizzbuzz_limit_bucket{version="v1",le="100"} 2
+fizzbuzz_limit_bucket{version="v1",le="1000"} 2
+fizzbuzz_limit_bucket{version="v1",le="10000"} 2
+fizzbuzz_limit_bucket{version="v1",le="100000"} 2
+fizzbuzz_limit_bucket{version="v1",le="1e+06"} 2
+fizzbuzz_limit_bucket{version="v1",le="1e+07"} 2
+fizzbuzz_limit_bucket{version="v1",le="1e+08"} 2
+fizzbuzz_limit_bucket{version="v1",le="1e+09"} 2
+fizzbuzz_limit_bucket{version="v1",le="+Inf"} 2
+fizzbuzz_limit_sum{version="v1"} 32
+fizzbuzz_limit_count{version="v1"} 2
+fizzbuzz_limit_bucket{version="v2",le="1"} 0
+fizzbuzz_limit_bucket{version="v2",le="10"} 0
+fizzbuzz_limit_bucket{version="v2",le="100"} 0
+fizzbuzz_limit_bucket{version="v2",le="1000"} 0
+fizzbuzz_limit_bucket{version="v2",le="10000"} 1
+fizzbuzz_limit_bucket{version="v2",le="100000"} 1
+fizzbuzz_limit_bucket{version="v2",le="1e+06"} 1
+fizzbuzz_limit_bucket{version="v2",le="1e+07"} 1
+fizzbuzz_limit_bucket{version="v2",le="1e+08"} 1
+fizzbuzz_limit_bucket{version="v2",le="1e+09"} 1
+fizzbuzz_limit_bucket{version="v2",le="+Inf"} 1
+fizzbuzz_limit_sum{version="v2"} 2000
+fizzbuzz_limit_count{version="v2"} 1
+`))
+	assertions.NoError(errCompare)
+}
+
+func Test_cardinalityCollector(t *testing.T) {
+	assertions := assert.New(t)
+
+	counter := stats.NewFizzbuzzCounter()
+	assertions.NoError(counter.Inc(context.Background(), fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16}))
+	assertions.NoError(counter.Inc(context.Background(), fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 17}))
+
+	errCompare := testutil.CollectAndCompare(newCardinalityCollector(counter), strings.NewReader(`
+# HELP fizzbuzz_counter_cardinality Number of distinct parameters (kind params) and rule sets (kind rules) counted.
+# TYPE fizzbuzz_counter_cardinality gauge
+fizzbuzz_counter_cardinality{kind="params"} 2
+fizzbuzz_counter_cardinality{kind="rules"} 0
+`))
+	assertions.NoError(errCompare)
+
+	// an error of the counter fails the scrape instead of exposing wrong values
+	errCollect := testutil.CollectAndCompare(newCardinalityCollector(failingCounter{counter}), strings.NewReader(""))
+	assertions.Error(errCollect)
+}
+
+func Test_cacheCollector(t *testing.T) {
+	cache := lrucache.New(20)
+	cache.Add("fizz", []byte("123456"))
+	cache.Add("buzz", []byte("123456"))
+	cache.Add("bazz", []byte("123456"))
+	cache.Get("bazz")
+	cache.Get("fizz")
+
+	errCompare := testutil.CollectAndCompare(newCacheCollector(cache), strings.NewReader(`
+# HELP fizzbuzz_response_cache_bytes Total size in bytes of the cached fizzbuzz responses.
+# TYPE fizzbuzz_response_cache_bytes gauge
+fizzbuzz_response_cache_bytes 20
+# HELP fizzbuzz_response_cache_entries Number of cached fizzbuzz responses.
+# TYPE fizzbuzz_response_cache_entries gauge
+fizzbuzz_response_cache_entries 2
+# HELP fizzbuzz_response_cache_evictions_total Number of fizzbuzz responses evicted from the cache to make room.
+# TYPE fizzbuzz_response_cache_evictions_total counter
+fizzbuzz_response_cache_evictions_total 1
+# HELP fizzbuzz_response_cache_hits_total Number of fizzbuzz responses found in the cache.
+# TYPE fizzbuzz_response_cache_hits_total counter
+fizzbuzz_response_cache_hits_total 1
+# HELP fizzbuzz_response_cache_misses_total Number of fizzbuzz responses not found in the cache.
+# TYPE fizzbuzz_response_cache_misses_total counter
+fizzbuzz_response_cache_misses_total 1
+`))
+	assert.NoError(t, errCompare)
+}
+
+func Test_Metrics_Handler(t *testing.T) {
+	assertions := assert.New(t)
+
+	m := New(stats.NewFizzbuzzCounter())
+	m.ObserveRequest("/fizzbuzz", "GET", http.StatusOK, 10*time.Millisecond)
+
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assertions.Equal(http.StatusOK, rr.Code)
+	assertions.Contains(rr.Header().Get("Content-Type"), "text/plain")
+	body, errRead := ioutil.ReadAll(rr.Body)
+	assertions.NoError(errRead)
+	assertions.Contains(string(body), `fizzbuzz_http_requests_total{code="200",method="GET",route="/fizzbuzz"} 1`)
+	assertions.Contains(string(body), `fizzbuzz_http_request_duration_seconds_count{route="/fizzbuzz"} 1`)
+	assertions.Contains(string(body), `fizzbuzz_counter_cardinality{kind="params"} 0`)
+	assertions.Contains(string(body), `go_goroutines`)
+}
+
+// failingCounter is a counter whose cardinality can't be retrieved
+type failingCounter struct {
+	stats.Counter
+}
+
+func (fc failingCounter) Cardinality(_ context.Context) (stats.Cardinality, error) {
+	return stats.Cardinality{}, errors.New("counter unavailable")
+}
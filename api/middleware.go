@@ -0,0 +1,335 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"math"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"fizzbuzz-server/api/clienterr"
+	"fizzbuzz-server/api/metrics"
+	"fizzbuzz-server/api/ratelimit"
+	"fizzbuzz-server/config"
+	"fizzbuzz-server/internal/requestid"
+	"fizzbuzz-server/internal/stats"
+)
+
+// Middleware wraps a handler to add a cross-cutting behavior, the ProcessFunc handlers being unaware of it
+type Middleware func(http.Handler) http.Handler
+
+// chain wraps the handler with the middlewares, the first middleware being the outermost
+func chain(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// requestIDMiddleware gives each request an ID, stored in its context and echoed in the response header
+// the ID received from the gateway is kept so the logs can be correlated, a new one is generated if
+// there is none or if it is not valid
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqID := r.Header.Get(requestid.Header)
+		if !requestid.Valid(reqID) {
+			reqID = requestid.New()
+		}
+		w.Header().Set(requestid.Header, reqID)
+		next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), reqID)))
+	})
+}
+
+// logsMiddleware logs each request when it is received and when its response is sent
+func logsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := requestid.Logger(r.Context())
+		logger.Info().
+			Str("address", r.RemoteAddr).
+			Str("method", r.Method).
+			Str("route", r.URL.Path).
+			Msg("received request")
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			// an aborted response is logged too, then the panic is passed on so the server closes the connection
+			recovered := recover()
+			event, msg := logger.Info(), "response sent"
+			if recovered != nil {
+				event, msg = logger.Warn(), "response aborted"
+			}
+			event.
+				Int("code", rec.status()).
+				Int("size", rec.size).
+				Dur("duration", time.Since(start)).
+				Msg(msg)
+			if recovered != nil {
+				panic(recovered)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// metricsMiddleware records the count, status code and duration of the requests of each route
+// the aborted responses are recorded with the status code they were sent with, and counted apart
+func metricsMiddleware(m *metrics.Metrics) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			defer func() {
+				recovered := recover()
+				m.ObserveRequest(routeOf(r), r.Method, rec.status(), time.Since(start))
+				if recovered != nil {
+					m.ObserveAbort(routeOf(r))
+					panic(recovered)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// apiKeyHeader is the HTTP header carrying the API key of the client
+const apiKeyHeader = "X-API-Key"
+
+// authMiddleware rejects the requests without a valid API key with a 401 error, except on the public routes
+// the caller is stored in the request context, so its requests are counted for its key
+func authMiddleware(apiKeys []config.APIKey, publicRoutes ...string) Middleware {
+	public := make(map[string]bool, len(publicRoutes))
+	for _, route := range publicRoutes {
+		public[route] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if public[routeOf(r)] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			apiKey := r.Header.Get(apiKeyHeader)
+			desc := "API key missing"
+			if apiKey != "" {
+				caller, ok := findCaller(apiKeys, apiKey)
+				if ok {
+					next.ServeHTTP(w, r.WithContext(stats.NewCallerContext(r.Context(), caller)))
+					return
+				}
+				desc = "invalid API key"
+			}
+			w.Header().Set("WWW-Authenticate", `APIKey header="`+apiKeyHeader+`"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(clienterr.ClientError{Code: http.StatusUnauthorized, Desc: desc}.GetErrorBody(r.Context()))
+		})
+	}
+}
+
+// findCaller gives the caller using this API key
+// the keys are compared in constant time, so the time taken does not tell how close a key is
+func findCaller(apiKeys []config.APIKey, apiKey string) (stats.Caller, bool) {
+	for _, key := range apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key.Key), []byte(apiKey)) == 1 {
+			return stats.Caller{Name: key.Name, Admin: key.Admin}, true
+		}
+	}
+	return stats.Caller{}, false
+}
+
+// rateLimitMiddleware rejects the requests of the clients that exhausted their tokens, with a 429 error
+// the clients are identified by their API key when authenticated, or by their IP otherwise
+// the headers of each response tell the client the state of its bucket, except on the exempt routes which are not limited
+func rateLimitMiddleware(limiter *ratelimit.Limiter, trustedProxies []*net.IPNet, exemptRoutes ...string) Middleware {
+	exempt := make(map[string]bool, len(exemptRoutes))
+	for _, route := range exemptRoutes {
+		exempt[route] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if exempt[routeOf(r)] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client := "ip:" + ratelimit.ClientIP(r, trustedProxies)
+			if caller, ok := stats.CallerFromContext(r.Context()); ok {
+				client = "key:" + caller.Name
+			}
+
+			res := limiter.Allow(client)
+			setRateLimitHeaders(w, res)
+			if !res.Allowed {
+				tooManyRequests(w, r, client, res)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ratelimit.NewContext(r.Context(), client)))
+		})
+	}
+}
+
+// authFailureLimitMiddleware charges a token to the IP of the client for each request rejected by the authentication,
+// and rejects the requests of the IPs that exhausted their tokens with a 429 error before their key is checked,
+// so the API keys can't be brute-forced
+// the requests authenticated are not charged to the IP, their key having its own bucket
+func authFailureLimitMiddleware(limiter *ratelimit.Limiter, trustedProxies []*net.IPNet, exemptRoutes ...string) Middleware {
+	exempt := make(map[string]bool, len(exemptRoutes))
+	for _, route := range exemptRoutes {
+		exempt[route] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if exempt[routeOf(r)] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client := "ip:" + ratelimit.ClientIP(r, trustedProxies)
+			if res := limiter.Peek(client); !res.Allowed {
+				setRateLimitHeaders(w, res)
+				tooManyRequests(w, r, client, res)
+				return
+			}
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.code == http.StatusUnauthorized {
+				limiter.Charge(client, 1)
+			}
+		})
+	}
+}
+
+// setRateLimitHeaders tells the client the state of its bucket
+func setRateLimitHeaders(w http.ResponseWriter, res ratelimit.Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+}
+
+// tooManyRequests answers the request of the client without tokens left with a 429 error
+func tooManyRequests(w http.ResponseWriter, r *http.Request, client string, res ratelimit.Result) {
+	logger := requestid.Logger(r.Context())
+	logger.Debug().Str("client", client).Msg("rate limit exceeded")
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(clienterr.ClientError{Code: http.StatusTooManyRequests, Desc: "too many requests"}.GetErrorBody(r.Context()))
+}
+
+// ceilSeconds gives the duration in whole seconds, rounded up
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// timeoutMiddleware sets the deadline of the processing of each request, the processes aborting once it passes
+// the streamed responses have their own deadline, as they can be much longer to send, zero meaning none
+func timeoutMiddleware(timeout time.Duration, streamTimeout time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := timeout
+			if isStream(r) {
+				timeout = streamTimeout
+			}
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// recoveryMiddleware answers the requests whose handler panicked with an internal error, instead of the
+// connection being dropped, logs the panic with its stack and counts it
+// once the response has started it can't be replaced, so the request is aborted to let the client know
+// the response is incomplete
+func recoveryMiddleware(m *metrics.Metrics) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &statusRecorder{ResponseWriter: w}
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+				if recovered == http.ErrAbortHandler {
+					// the request is deliberately aborted
+					panic(recovered)
+				}
+
+				m.ObservePanic(routeOf(r))
+				logger := requestid.Logger(r.Context())
+				logger.Error().
+					Interface("panic", recovered).
+					Str("stack", string(debug.Stack())).
+					Msg("panic while handling request")
+
+				if rec.code != 0 {
+					panic(http.ErrAbortHandler)
+				}
+				// the headers set by the handler are those of a response that won't be sent
+				for key := range w.Header() {
+					if key != http.CanonicalHeaderKey(requestid.Header) {
+						w.Header().Del(key)
+					}
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write(clienterr.InternalError.GetErrorBody(r.Context()))
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// statusRecorder keeps the status code and the size of the response written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+	size int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.code == 0 {
+		rec.code = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(p []byte) (int, error) {
+	if rec.code == 0 {
+		rec.code = http.StatusOK
+	}
+	n, errWrite := rec.ResponseWriter.Write(p)
+	rec.size += n
+	return n, errWrite
+}
+
+// Flush keeps streamed responses flushed when the wrapped writer supports it
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives the wrapped writer, so the deadlines of the connection can be set through the recorder
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// status gives the status code of the response, nothing written yet meaning a 200
+func (rec *statusRecorder) status() int {
+	if rec.code == 0 {
+		return http.StatusOK
+	}
+	return rec.code
+}
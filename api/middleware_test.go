@@ -0,0 +1,509 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fizzbuzz-server/api/clienterr"
+	"fizzbuzz-server/api/metrics"
+	"fizzbuzz-server/config"
+	"fizzbuzz-server/internal/requestid"
+	"fizzbuzz-server/internal/stats"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_chain(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	})
+
+	chain(handler, record("first"), record("second")).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assertions.Equal([]string{"first", "second", "handler"}, calls)
+
+	// without middleware the handler is called directly
+	calls = nil
+	chain(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assertions.Equal([]string{"handler"}, calls)
+}
+
+func Test_requestIDMiddleware(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		inboundID string
+		wantID    string
+	}{
+		"OK - generated": {
+			inboundID: "",
+		},
+		"OK - inbound ID kept": {
+			inboundID: "gateway-1234",
+			wantID:    "gateway-1234",
+		},
+		"OK - invalid inbound ID replaced": {
+			inboundID: "fizz buzz",
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assertions := assert.New(t)
+
+			var gotID string
+			handler := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotID = requestid.FromContext(r.Context())
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.inboundID != "" {
+				r.Header.Set("X-Request-ID", tt.inboundID)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+
+			if tt.wantID != "" {
+				assertions.Equal(tt.wantID, gotID)
+			} else {
+				assertions.True(requestid.Valid(gotID))
+				assertions.NotEqual(tt.inboundID, gotID)
+			}
+			assertions.Equal(gotID, rr.Header().Get("X-Request-ID"), "the ID is echoed to the client")
+		})
+	}
+}
+
+func Test_Init_requestID(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	api := Init(config.Conf{MiddlewareRequestID: true}, stats.NewFizzbuzzCounter())
+	r := httptest.NewRequest("GET", "/topreq?k=fizz", nil)
+	r.Header.Set("X-Request-ID", "gateway-1234")
+	rr := httptest.NewRecorder()
+	api.Handler.ServeHTTP(rr, r)
+
+	assertions.Equal(http.StatusBadRequest, rr.Code)
+	assertions.Equal("gateway-1234", rr.Header().Get("X-Request-ID"))
+	assertions.Equal(`{"code":400,"desc":"k must be an integer","requestId":"gateway-1234"}`, rr.Body.String())
+}
+
+func Test_metricsMiddleware(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	m := metrics.New(stats.NewFizzbuzzCounter())
+	handler := metricsMiddleware(m)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), withRoute(httptest.NewRequest("GET", "/fizzbuzz?limit=3", nil), "/fizzbuzz"))
+
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assertions.Contains(rr.Body.String(), `fizzbuzz_http_requests_total{code="418",method="GET",route="/fizzbuzz"} 1`)
+
+	// an aborted response is recorded before the panic is passed on
+	aborting := metricsMiddleware(m)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fizz"))
+		panic(http.ErrAbortHandler)
+	}))
+	assertions.PanicsWithValue(http.ErrAbortHandler, func() {
+		aborting.ServeHTTP(httptest.NewRecorder(), withRoute(httptest.NewRequest("GET", "/fizzbuzz?stream=true", nil), "/fizzbuzz"))
+	})
+
+	rr = httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assertions.Contains(rr.Body.String(), `fizzbuzz_http_requests_total{code="200",method="GET",route="/fizzbuzz"} 1`)
+	assertions.Contains(rr.Body.String(), `fizzbuzz_http_requests_aborted_total{route="/fizzbuzz"} 1`)
+}
+
+func Test_Init_rateLimit(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	api := Init(config.Conf{RateLimitRate: 0.001, RateLimitBurst: 3, RateLimitElementsPerToken: 1000}, stats.NewFizzbuzzCounter())
+	get := func(path string, apiKey string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		rr := httptest.NewRecorder()
+		api.Handler.ServeHTTP(rr, r)
+		return rr
+	}
+
+	rr := get("/fizzbuzz?int1=3&int2=5&limit=100", "")
+	assertions.Equal(http.StatusOK, rr.Code)
+	assertions.Equal("3", rr.Header().Get("RateLimit-Limit"))
+	assertions.Equal("2", rr.Header().Get("RateLimit-Remaining"))
+	assertions.Equal("1000", rr.Header().Get("RateLimit-Reset"))
+
+	// the cost is weighted by the limit, the bucket is now in debt
+	assertions.Equal(http.StatusOK, get("/fizzbuzz?int1=3&int2=5&limit=2000", "").Code)
+	rr = get("/mostfreqreq", "")
+	assertions.Equal(http.StatusTooManyRequests, rr.Code)
+	assertions.Equal(`{"code":429,"desc":"too many requests"}`, rr.Body.String())
+	assertions.Equal("0", rr.Header().Get("RateLimit-Remaining"))
+	assertions.Equal("2100", rr.Header().Get("Retry-After"))
+	assertions.Equal("4100", rr.Header().Get("RateLimit-Reset"))
+
+	// without authentication, an API key does not give another bucket
+	assertions.Equal(http.StatusTooManyRequests, get("/mostfreqreq", "fizz").Code)
+	assertions.Equal(http.StatusTooManyRequests, get("/mostfreqreq", "buzz").Code)
+
+	// the probes and the metrics are not limited
+	for _, path := range []string{"/healthz", "/readyz", "/metrics"} {
+		rr = get(path, "")
+		assertions.Equal(http.StatusOK, rr.Code, path)
+		assertions.Empty(rr.Header().Get("RateLimit-Limit"), path)
+	}
+
+	// a page is charged its own elements, not the limit of the whole sequence
+	api = Init(config.Conf{RateLimitRate: 0.001, RateLimitBurst: 3, RateLimitElementsPerToken: 1000}, stats.NewFizzbuzzCounter())
+	body := `{"rules":[{"divisor":3,"word":"fizz"},{"divisor":5,"word":"buzz"}],"limit":2000000000,"offset":1000000,"count":100}`
+	rr = httptest.NewRecorder()
+	api.Handler.ServeHTTP(rr, httptest.NewRequest("POST", "/v2/fizzbuzz", strings.NewReader(body)))
+	assertions.Equal(http.StatusOK, rr.Code)
+	rr = get("/mostfreqreq", "")
+	assertions.Equal(http.StatusOK, rr.Code)
+	assertions.Equal("0", rr.Header().Get("RateLimit-Remaining"))
+	assertions.Equal("2100", rr.Header().Get("RateLimit-Reset"), "1 then 1.1 tokens charged")
+}
+
+func Test_Init_auth(t *testing.T) {
+	t.Parallel()
+
+	conf := config.Conf{
+		ParsedAPIKeys: []config.APIKey{
+			{Name: "client1", Key: "k1"},
+			{Name: "client2", Key: "k2"},
+			{Name: "root", Key: "k0", Admin: true},
+		},
+		RateLimitRate:             0.001,
+		RateLimitBurst:            3,
+		RateLimitElementsPerToken: 1000,
+	}
+	api := Init(conf, stats.NewKeyedCounter(stats.NewFizzbuzzCounter(), func(string) stats.Counter { return stats.NewFizzbuzzCounter() }))
+	get := func(path string, apiKey string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		rr := httptest.NewRecorder()
+		api.Handler.ServeHTTP(rr, r)
+		return rr
+	}
+	// each client requests its own params once
+	for _, apiKey := range []string{"k1", "k2", "k0"} {
+		require.Equal(t, http.StatusOK, get("/fizzbuzz?int1=3&int2=5&limit=10&str1="+apiKey, apiKey).Code)
+	}
+
+	tests := map[string]struct {
+		path     string
+		apiKey   string
+		wantCode int
+		wantBody string
+	}{
+		"OK - own statistics": {
+			path:     "/mostfreqreq",
+			apiKey:   "k1",
+			wantCode: http.StatusOK,
+			wantBody: `{"count":1,"params":[{"int1":3,"int2":5,"limit":10,"str1":"k1","str2":""}]}`,
+		},
+		"OK - global statistics for the admins": {
+			path:     "/mostfreqreq",
+			apiKey:   "k0",
+			wantCode: http.StatusOK,
+			wantBody: `{"count":1,"params":[{"int1":3,"int2":5,"limit":10,"str1":"k0","str2":""},` +
+				`{"int1":3,"int2":5,"limit":10,"str1":"k1","str2":""},{"int1":3,"int2":5,"limit":10,"str1":"k2","str2":""}]}`,
+		},
+		"OK - public route": {
+			path:     "/healthz",
+			wantCode: http.StatusOK,
+			wantBody: `{"status":"ok"}`,
+		},
+		"KO - missing key": {
+			path:     "/mostfreqreq",
+			wantCode: http.StatusUnauthorized,
+			wantBody: `{"code":401,"desc":"API key missing"}`,
+		},
+		"KO - invalid key": {
+			path:     "/mostfreqreq",
+			apiKey:   "k3",
+			wantCode: http.StatusUnauthorized,
+			wantBody: `{"code":401,"desc":"invalid API key"}`,
+		},
+		"KO - rate limited by key": {
+			path:     "/topreq",
+			apiKey:   "k2",
+			wantCode: http.StatusTooManyRequests,
+			wantBody: `{"code":429,"desc":"too many requests"}`,
+		},
+	}
+	// exhaust the tokens of the second client
+	require.Equal(t, http.StatusOK, get("/mostfreqreq", "k2").Code)
+
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			rr := get(tt.path, tt.apiKey)
+			assertions.Equal(tt.wantCode, rr.Code)
+			assertions.Equal(tt.wantBody, rr.Body.String())
+			if tt.wantCode == http.StatusUnauthorized {
+				assertions.Equal(`APIKey header="X-API-Key"`, rr.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func Test_Init_authFailureLimit(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	api := Init(config.Conf{
+		ParsedAPIKeys:             []config.APIKey{{Name: "client1", Key: "k1"}},
+		RateLimitRate:             0.001,
+		RateLimitBurst:            3,
+		RateLimitElementsPerToken: 1000,
+	}, stats.NewFizzbuzzCounter())
+	get := func(path string, remoteAddr string, apiKey string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = remoteAddr
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		rr := httptest.NewRecorder()
+		api.Handler.ServeHTTP(rr, r)
+		return rr
+	}
+
+	// the probes and the authenticated requests are not charged to the IP
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusOK, get("/healthz", "192.0.2.1:1234", "").Code)
+	}
+	require.Equal(t, http.StatusOK, get("/mostfreqreq", "192.0.2.1:1234", "k1").Code)
+
+	// each failure costs a token to the IP, whatever the key tried
+	for _, apiKey := range []string{"", "fizz", "buzz"} {
+		assertions.Equal(http.StatusUnauthorized, get("/mostfreqreq", "192.0.2.1:1234", apiKey).Code)
+	}
+	rr := get("/mostfreqreq", "192.0.2.1:1234", "bazz")
+	assertions.Equal(http.StatusTooManyRequests, rr.Code)
+	assertions.Equal(`{"code":429,"desc":"too many requests"}`, rr.Body.String())
+	assertions.Equal("1000", rr.Header().Get("Retry-After"))
+	// the key is not even checked anymore
+	assertions.Equal(http.StatusTooManyRequests, get("/mostfreqreq", "192.0.2.1:1234", "k1").Code)
+
+	// the other IPs and the probes are not affected
+	assertions.Equal(http.StatusOK, get("/mostfreqreq", "192.0.2.2:1234", "k1").Code)
+	assertions.Equal(http.StatusOK, get("/readyz", "192.0.2.1:1234", "").Code)
+}
+
+func Test_timeoutMiddleware(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	var gotDeadline time.Time
+	var gotOk bool
+	handler := timeoutMiddleware(time.Minute, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotDeadline, gotOk = r.Context().Deadline()
+	}))
+	start := time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	assertions.True(gotOk, "no deadline")
+	assertions.WithinDuration(start.Add(time.Minute), gotDeadline, time.Second)
+
+	// the streamed responses have their own deadline
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?stream=true", nil))
+	assertions.False(gotOk, "deadline set for streams")
+	handler = timeoutMiddleware(time.Minute, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotDeadline, gotOk = r.Context().Deadline()
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?stream=true", nil))
+	assertions.True(gotOk, "no deadline for streams")
+	assertions.WithinDuration(start.Add(time.Hour), gotDeadline, time.Second)
+}
+
+func Test_recoveryMiddleware(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		handler    http.HandlerFunc
+		wantCode   int
+		wantBody   string
+		wantPanic  interface{}
+		wantPanics float64
+	}{
+		"OK - no panic": {
+			handler:  func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("fizz")) },
+			wantCode: http.StatusOK,
+			wantBody: "fizz",
+		},
+		"KO - panic": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Total-Count", "100")
+				panic("fizz")
+			},
+			wantCode:   http.StatusInternalServerError,
+			wantBody:   `{"code":500,"desc":"internal error"}`,
+			wantPanics: 1,
+		},
+		"KO - panic with an error": {
+			handler:    func(w http.ResponseWriter, r *http.Request) { panic(errors.New("buzz")) },
+			wantCode:   http.StatusInternalServerError,
+			wantBody:   `{"code":500,"desc":"internal error"}`,
+			wantPanics: 1,
+		},
+		"KO - panic once the response started": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("fizz"))
+				panic("buzz")
+			},
+			wantCode:   http.StatusOK,
+			wantBody:   "fizz",
+			wantPanic:  http.ErrAbortHandler,
+			wantPanics: 1,
+		},
+		"KO - aborted": {
+			handler:   func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) },
+			wantCode:  http.StatusOK,
+			wantPanic: http.ErrAbortHandler,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assertions := assert.New(t)
+
+			m := metrics.New(stats.NewFizzbuzzCounter())
+			rr := httptest.NewRecorder()
+			r := withRoute(httptest.NewRequest("GET", "/fizzbuzz", nil), "/fizzbuzz")
+			serve := func() { recoveryMiddleware(m)(tt.handler).ServeHTTP(rr, r) }
+			if tt.wantPanic != nil {
+				assertions.PanicsWithValue(tt.wantPanic, serve)
+			} else {
+				assertions.NotPanics(serve)
+			}
+			assertions.Equal(tt.wantCode, rr.Code)
+			assertions.Equal(tt.wantBody, rr.Body.String())
+			if tt.wantCode == http.StatusInternalServerError {
+				assertions.Equal(http.Header{"Content-Type": {"application/json"}}, rr.Header(), "headers of the handler sent")
+			}
+
+			scrape := httptest.NewRecorder()
+			m.Handler().ServeHTTP(scrape, httptest.NewRequest("GET", "/metrics", nil))
+			if tt.wantPanics > 0 {
+				assertions.Contains(scrape.Body.String(), `fizzbuzz_http_panics_total{route="/fizzbuzz"} 1`)
+			} else {
+				assertions.NotContains(scrape.Body.String(), "fizzbuzz_http_panics_total{")
+			}
+		})
+	}
+}
+
+func Test_Init_panickingHandler(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	api := Init(config.Conf{MiddlewareRequestID: true, MiddlewareMetrics: true, MiddlewareRecovery: true}, stats.NewFizzbuzzCounter())
+	handler := api.wrapProcess(func(r *http.Request, _ stats.Counter) (int, map[string][]string, []byte, error) {
+		panic("fizzbuzz")
+	})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, withRoute(httptest.NewRequest("GET", "/panic", nil), "/panic"))
+
+	assertions.Equal(http.StatusInternalServerError, rr.Code)
+	var gotErr clienterr.ClientError
+	assertions.NoError(json.Unmarshal(rr.Body.Bytes(), &gotErr))
+	assertions.Equal(http.StatusInternalServerError, gotErr.Code)
+	assertions.Equal("internal error", gotErr.Desc)
+	assertions.NotEmpty(gotErr.RequestID, "the request ID allows to find the logs of the panic")
+	assertions.Equal(gotErr.RequestID, rr.Header().Get("X-Request-ID"))
+
+	// the internal error is recorded as any response
+	scrape := httptest.NewRecorder()
+	api.metrics.Handler().ServeHTTP(scrape, httptest.NewRequest("GET", "/metrics", nil))
+	assertions.Contains(scrape.Body.String(), `fizzbuzz_http_requests_total{code="500",method="GET",route="/panic"} 1`)
+	assertions.Contains(scrape.Body.String(), `fizzbuzz_http_panics_total{route="/panic"} 1`)
+}
+
+func Test_statusRecorder(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		write    func(w http.ResponseWriter)
+		wantCode int
+		wantSize int
+	}{
+		"OK - nothing written": {
+			write:    func(w http.ResponseWriter) {},
+			wantCode: http.StatusOK,
+		},
+		"OK - body without status code": {
+			write:    func(w http.ResponseWriter) { w.Write([]byte("fizz")) },
+			wantCode: http.StatusOK,
+			wantSize: 4,
+		},
+		"OK - first status code kept": {
+			write: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("fizz"))
+				w.Write([]byte("buzz"))
+			},
+			wantCode: http.StatusBadRequest,
+			wantSize: 8,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assertions := assert.New(t)
+
+			rec := &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+			tt.write(rec)
+			assertions.Equal(tt.wantCode, rec.status())
+			assertions.Equal(tt.wantSize, rec.size)
+		})
+	}
+}
+
+func Test_Init_middlewaresDisabled(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	api := Init(config.Conf{}, stats.NewFizzbuzzCounter())
+	rr := httptest.NewRecorder()
+	api.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	assertions.Equal(http.StatusOK, rr.Code)
+
+	// the metrics route is still served, but no request is recorded
+	rr = httptest.NewRecorder()
+	api.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assertions.Equal(http.StatusOK, rr.Code)
+	assertions.Contains(rr.Body.String(), "fizzbuzz_counter_cardinality")
+	assertions.NotContains(rr.Body.String(), "fizzbuzz_http_requests_total{")
+}
@@ -2,7 +2,6 @@ package mostfreqreqhandler
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 
@@ -11,28 +10,51 @@ import (
 )
 
 // ProcessMostFrequentReq does all the process of a mostfreqreq request
-func ProcessMostFrequentReq(r *http.Request, counter stats.FizzbuzzCounter) (int, map[string][]string, []byte, error) {
-	// check method
-	if r.Method != "GET" {
-		return http.StatusMethodNotAllowed,
-			map[string][]string{"Allow": {"GET"}},
-			clienterr.ClientError{Code: http.StatusMethodNotAllowed, Desc: "method not allowed"}.GetErrorBody(),
-			errors.New("invalid method")
-	}
-
+func ProcessMostFrequentReq(r *http.Request, counter stats.Counter) (int, map[string][]string, []byte, error) {
 	// retrieve most frequent request
-	mostFreReq := counter.MostFrequentReq()
+	mostFreReq, errCounter := counter.MostFrequentReq(r.Context())
+	if errCounter != nil {
+		return http.StatusInternalServerError,
+			clienterr.Headers(),
+			clienterr.InternalError.GetErrorBody(r.Context()),
+			fmt.Errorf("error retrieving most frequent request: %w", errCounter)
+	}
 
 	// create response
 	body, errJson := json.Marshal(mostFreReq)
 	if errJson != nil {
 		return http.StatusInternalServerError,
-			map[string][]string{},
-			clienterr.InternalError.GetErrorBody(),
+			clienterr.Headers(),
+			clienterr.InternalError.GetErrorBody(r.Context()),
+			fmt.Errorf("error marshalling json: %w", errJson)
+	}
+	return http.StatusOK,
+		map[string][]string{"Content-Type": {"application/json"}},
+		body,
+		nil
+}
+
+// ProcessMostFrequentRules does all the process of a mostfreqreq request on the generalized fizzbuzz
+func ProcessMostFrequentRules(r *http.Request, counter stats.Counter) (int, map[string][]string, []byte, error) {
+	// retrieve most frequent rule sets
+	mostFreqRules, errCounter := counter.MostFrequentRules(r.Context())
+	if errCounter != nil {
+		return http.StatusInternalServerError,
+			clienterr.Headers(),
+			clienterr.InternalError.GetErrorBody(r.Context()),
+			fmt.Errorf("error retrieving most frequent rules: %w", errCounter)
+	}
+
+	// create response
+	body, errJson := json.Marshal(mostFreqRules)
+	if errJson != nil {
+		return http.StatusInternalServerError,
+			clienterr.Headers(),
+			clienterr.InternalError.GetErrorBody(r.Context()),
 			fmt.Errorf("error marshalling json: %w", errJson)
 	}
 	return http.StatusOK,
-		map[string][]string{},
+		map[string][]string{"Content-Type": {"application/json"}},
 		body,
 		nil
 }
@@ -1,6 +1,7 @@
 package mostfreqreqhandler
 
 import (
+	"context"
 	"fizzbuzz-server/internal/fizzbuzz"
 	"fizzbuzz-server/internal/stats"
 	"net/http"
@@ -12,7 +13,7 @@ import (
 func Test_ProcessMostFrequentReq(t *testing.T) {
 	tests := map[string]struct {
 		req         *http.Request
-		counter     stats.FizzbuzzCounter
+		counter     stats.Counter
 		wantCode    int
 		wantHeaders map[string][]string
 		wantBody    []byte
@@ -22,33 +23,73 @@ func Test_ProcessMostFrequentReq(t *testing.T) {
 			req: &http.Request{
 				Method: "GET",
 			},
-			counter: stats.FizzbuzzCounter{
+			counter: newCounter(map[fizzbuzz.Params]int{
 				fizzbuzz.Params{Int1: 3, Int2: 4, Limit: 12, Str1: "fizz", Str2: "buzz"}: 1,
 				fizzbuzz.Params{Int1: 1, Int2: 2, Limit: 12, Str1: "fizz", Str2: "buzz"}: 2,
-			},
+			}),
 			wantCode:    http.StatusOK,
-			wantHeaders: map[string][]string{},
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}},
 			wantBody:    []byte(`{"count":2,"params":[{"int1":1,"int2":2,"limit":12,"str1":"fizz","str2":"buzz"}]}`),
 		},
-		"KO - method not allowed": {
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			gotCode, gotHeaders, gotBody, gotErr := ProcessMostFrequentReq(tt.req, tt.counter)
+
+			if tt.wantErrStr != "" {
+				assertions.Contains(gotErr.Error(), tt.wantErrStr)
+			} else {
+				assertions.NoError(gotErr)
+			}
+			assertions.Equal(tt.wantCode, gotCode)
+			assertions.Equal(tt.wantHeaders, gotHeaders)
+			assertions.Equal(tt.wantBody, gotBody)
+		})
+	}
+}
+
+func Test_ProcessMostFrequentRules(t *testing.T) {
+	ruleSet1 := fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}, {Divisor: 7, Word: "bazz"}}, Limit: 12}
+	ruleSet2 := fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 2, Word: "fizz"}}, Limit: 12}
+
+	tests := map[string]struct {
+		req         *http.Request
+		incs        []fizzbuzz.RuleSet
+		wantCode    int
+		wantHeaders map[string][]string
+		wantBody    []byte
+		wantErrStr  string
+	}{
+		"OK": {
 			req: &http.Request{
-				Method: "POST",
+				Method: "GET",
 			},
-			counter: stats.FizzbuzzCounter{
-				fizzbuzz.Params{Int1: 3, Int2: 4, Limit: 12, Str1: "fizz", Str2: "buzz"}: 1,
-				fizzbuzz.Params{Int1: 1, Int2: 2, Limit: 12, Str1: "fizz", Str2: "buzz"}: 2,
+			incs:        []fizzbuzz.RuleSet{ruleSet1, ruleSet2, ruleSet1},
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}},
+			wantBody:    []byte(`{"count":2,"ruleSets":[{"rules":[{"divisor":3,"word":"fizz"},{"divisor":7,"word":"bazz"}],"limit":12}]}`),
+		},
+		"OK - empty": {
+			req: &http.Request{
+				Method: "GET",
 			},
-			wantCode:    http.StatusMethodNotAllowed,
-			wantHeaders: map[string][]string{"Allow": {"GET"}},
-			wantBody:    []byte(`{"code":405,"desc":"method not allowed"}`),
-			wantErrStr:  "invalid method",
+			incs:        []fizzbuzz.RuleSet{},
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}},
+			wantBody:    []byte(`{"count":0,"ruleSets":[]}`),
 		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			assertions := assert.New(t)
 
-			gotCode, gotHeaders, gotBody, gotErr := ProcessMostFrequentReq(tt.req, tt.counter)
+			counter := stats.NewFizzbuzzCounter()
+			for _, ruleSet := range tt.incs {
+				counter.IncRules(context.Background(), ruleSet)
+			}
+			gotCode, gotHeaders, gotBody, gotErr := ProcessMostFrequentRules(tt.req, counter)
 
 			if tt.wantErrStr != "" {
 				assertions.Contains(gotErr.Error(), tt.wantErrStr)
@@ -61,3 +102,14 @@ func Test_ProcessMostFrequentReq(t *testing.T) {
 		})
 	}
 }
+
+// newCounter creates a counter already incremented with these counts
+func newCounter(counts map[fizzbuzz.Params]int) stats.Counter {
+	counter := stats.NewFizzbuzzCounter()
+	for params, count := range counts {
+		for i := 0; i < count; i++ {
+			counter.Inc(context.Background(), params)
+		}
+	}
+	return counter
+}
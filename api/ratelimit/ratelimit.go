@@ -0,0 +1,236 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"fizzbuzz-server/internal/fizzbuzz"
+	"fizzbuzz-server/internal/stats"
+)
+
+// sweepInterval is the minimum time between two removals of the full buckets, which are the same as new ones
+const sweepInterval = time.Minute
+
+// maxDebt bounds the debt of a client to the tokens refilled in this time, however expensive its requests
+const maxDebt = 24 * time.Hour
+
+// Limiter is a token bucket rate limiter, each client having its own bucket
+// a bucket holds up to burst tokens and is refilled at rate tokens per second
+// it is safe for concurrent use
+type Limiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// bucket holds the tokens of a client as of its last update
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Result is the state of the bucket of a client after a request
+type Result struct {
+	Allowed bool
+	// Limit is the capacity of the bucket
+	Limit int
+	// Remaining is the number of whole tokens left in the bucket
+	Remaining int
+	// RetryAfter is the time until a token is available, zero if there is one
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full
+	Reset time.Duration
+}
+
+// NewLimiter creates a limiter refilling the buckets at rate tokens per second, up to burst tokens
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the bucket of the client if it has one, the request being allowed
+func (l *Limiter) Allow(client string) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(client)
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return l.result(b, allowed)
+}
+
+// Peek gives the state of the bucket of the client without taking a token, the request being allowed if it has one
+func (l *Limiter) Peek(client string) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(client)
+	return l.result(b, b.tokens >= 1)
+}
+
+// Charge takes the cost from the bucket of the client, even if it has not enough tokens
+// the client is then limited until its bucket is refilled
+func (l *Limiter) Charge(client string, cost float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(client)
+	b.tokens = math.Max(b.tokens-cost, -maxDebt.Seconds()*l.rate)
+}
+
+// refill gives the bucket of the client with the tokens earned since its last update, a new bucket being full
+// the caller must hold the lock
+func (l *Limiter) refill(client string) *bucket {
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+		return b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	return b
+}
+
+// sweep removes the buckets that would be full, so the clients gone are forgotten
+// the caller must hold the lock
+func (l *Limiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+func (l *Limiter) result(b *bucket, allowed bool) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     int(l.burst),
+		Remaining: int(math.Max(0, math.Floor(b.tokens))),
+		Reset:     l.timeFor(l.burst - b.tokens),
+	}
+	if b.tokens < 1 {
+		res.RetryAfter = l.timeFor(1 - b.tokens)
+	}
+	return res
+}
+
+// timeFor gives the time needed to earn these tokens, bounded so the conversion can't overflow
+func (l *Limiter) timeFor(tokens float64) time.Duration {
+	nanos := tokens / l.rate * float64(time.Second)
+	if nanos <= 0 {
+		return 0
+	}
+	if nanos >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(nanos)
+}
+
+// clientKey is the context key of the client of the request
+type clientKey struct{}
+
+// NewContext gives a copy of the context carrying the client of the request, whose bucket is charged by the counter
+func NewContext(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// Counter wraps the counter so each counted request is charged to its client according to the number of elements
+// computed, its limit or the size of its page, a token for each elementsPerToken elements
+// the request is counted and processed anyway, its client being limited afterwards
+func (l *Limiter) Counter(counter stats.Counter, elementsPerToken int) stats.Counter {
+	return chargedCounter{Counter: counter, limiter: l, elementsPerToken: float64(elementsPerToken)}
+}
+
+// chargedCounter is a counter charging the requests it counts to their client
+type chargedCounter struct {
+	stats.Counter
+	limiter          *Limiter
+	elementsPerToken float64
+}
+
+func (cc chargedCounter) Inc(ctx context.Context, params fizzbuzz.Params) error {
+	if errInc := cc.Counter.Inc(ctx, params); errInc != nil {
+		return errInc
+	}
+	cc.charge(ctx, params.Limit)
+	return nil
+}
+
+func (cc chargedCounter) IncRules(ctx context.Context, ruleSet fizzbuzz.RuleSet) error {
+	if errInc := cc.Counter.IncRules(ctx, ruleSet); errInc != nil {
+		return errInc
+	}
+	elements := ruleSet.Limit
+	if page, ok := stats.PageFromContext(ctx); ok {
+		elements = page.Size(ruleSet.Limit)
+	}
+	cc.charge(ctx, elements)
+	return nil
+}
+
+// charge charges the cost of these elements to the client of the request, if it is known
+func (cc chargedCounter) charge(ctx context.Context, elements int) {
+	if client, ok := ctx.Value(clientKey{}).(string); ok {
+		cc.limiter.Charge(client, float64(elements)/cc.elementsPerToken)
+	}
+}
+
+// ClientIP gives the IP of the client of the request
+// when the request comes from a trusted proxy, the client is the last address of the X-Forwarded-For header
+// not added by a trusted proxy
+func ClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	host, _, errSplit := net.SplitHostPort(r.RemoteAddr)
+	if errSplit != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !trusted(ip, trustedProxies) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			// the addresses before a malformed one can't be trusted
+			break
+		}
+		ip = hop
+		if !trusted(hop, trustedProxies) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+// trusted tells if the IP is one of a trusted proxy
+func trusted(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, proxies := range trustedProxies {
+		if proxies.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"fizzbuzz-server/internal/fizzbuzz"
+	"fizzbuzz-server/internal/stats"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestLimiter creates a limiter whose clock is advanced by the returned func
+func newTestLimiter(rate float64, burst int) (*Limiter, func(time.Duration)) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(rate, burst)
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func Test_Limiter_Allow(t *testing.T) {
+	assertions := assert.New(t)
+
+	l, advance := newTestLimiter(2, 3)
+
+	// a new client has a full bucket
+	assertions.Equal(Result{Allowed: true, Limit: 3, Remaining: 2, Reset: 500 * time.Millisecond}, l.Allow("fizz"))
+	assertions.Equal(Result{Allowed: true, Limit: 3, Remaining: 1, Reset: time.Second}, l.Allow("fizz"))
+	assertions.Equal(Result{Allowed: true, Limit: 3, Remaining: 0, Reset: 1500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}, l.Allow("fizz"))
+	assertions.Equal(Result{Allowed: false, Limit: 3, Remaining: 0, Reset: 1500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}, l.Allow("fizz"))
+
+	// each client has its own bucket
+	assertions.True(l.Allow("buzz").Allowed)
+
+	// the bucket is refilled over time, up to its capacity
+	advance(500 * time.Millisecond)
+	assertions.True(l.Allow("fizz").Allowed)
+	assertions.False(l.Allow("fizz").Allowed)
+	advance(time.Hour)
+	assertions.Equal(2, l.Allow("fizz").Remaining)
+}
+
+func Test_Limiter_Peek(t *testing.T) {
+	assertions := assert.New(t)
+
+	l, _ := newTestLimiter(1, 2)
+
+	// the tokens are not taken
+	assertions.Equal(Result{Allowed: true, Limit: 2, Remaining: 2}, l.Peek("fizz"))
+	assertions.Equal(Result{Allowed: true, Limit: 2, Remaining: 2}, l.Peek("fizz"))
+	l.Charge("fizz", 2)
+	assertions.Equal(Result{Allowed: false, Limit: 2, Remaining: 0, RetryAfter: time.Second, Reset: 2 * time.Second}, l.Peek("fizz"))
+}
+
+func Test_Limiter_Charge(t *testing.T) {
+	assertions := assert.New(t)
+
+	l, advance := newTestLimiter(1, 10)
+
+	assertions.True(l.Allow("fizz").Allowed)
+	l.Charge("fizz", 14)
+
+	// the client is limited until its debt is paid
+	assertions.Equal(Result{Allowed: false, Limit: 10, Remaining: 0, RetryAfter: 6 * time.Second, Reset: 15 * time.Second}, l.Allow("fizz"))
+	advance(5 * time.Second)
+	assertions.False(l.Allow("fizz").Allowed)
+	advance(time.Second)
+	assertions.True(l.Allow("fizz").Allowed)
+
+	// the debt is bounded, so is the time the client is limited for
+	l.Charge("buzz", math.MaxFloat64)
+	assertions.Equal(Result{Allowed: false, Limit: 10, Remaining: 0, RetryAfter: maxDebt + time.Second, Reset: maxDebt + 10*time.Second}, l.Peek("buzz"))
+
+	// the times too long for a duration are bounded instead of overflowing
+	l, _ = newTestLimiter(1e-12, 1)
+	assertions.True(l.Allow("fizz").Allowed)
+	assertions.Equal(Result{Allowed: false, Limit: 1, Remaining: 0, RetryAfter: math.MaxInt64, Reset: math.MaxInt64}, l.Peek("fizz"))
+}
+
+func Test_Limiter_sweep(t *testing.T) {
+	assertions := assert.New(t)
+
+	l, advance := newTestLimiter(1, 10)
+	l.Allow("fizz")
+	l.Charge("buzz", 100)
+
+	advance(sweepInterval)
+	l.Allow("fizzbuzz")
+
+	// the refilled buckets are forgotten, the others are kept
+	assertions.NotContains(l.buckets, "fizz")
+	assertions.Contains(l.buckets, "buzz")
+	assertions.Contains(l.buckets, "fizzbuzz")
+}
+
+func Test_Limiter_Counter(t *testing.T) {
+	assertions := assert.New(t)
+
+	l, _ := newTestLimiter(1, 100)
+	counter := stats.NewFizzbuzzCounter()
+	charged := l.Counter(counter, 1000)
+
+	ctx := NewContext(context.Background(), "fizz")
+	assertions.NoError(charged.Inc(ctx, fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 20000}))
+	assertions.NoError(charged.IncRules(ctx, fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}}, Limit: 50000}))
+	// without client, the request is not charged
+	assertions.NoError(charged.Inc(context.Background(), fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 1000000}))
+	// only the elements of the page requested are charged, up to the limit
+	ruleSet := fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}}, Limit: 2000000000}
+	assertions.NoError(charged.IncRules(stats.NewPageContext(ctx, fizzbuzz.Page{Offset: 1000000, Count: 3000}), ruleSet))
+	assertions.NoError(charged.IncRules(stats.NewPageContext(ctx, fizzbuzz.Page{Offset: ruleSet.Limit - 5000, Count: 1000000}), ruleSet))
+
+	assertions.Equal(21, l.Allow("fizz").Remaining, "20, 50, 3 then 5 tokens charged")
+	// the requests are still counted by the wrapped counter
+	gotCount, gotErr := counter.Get(context.Background(), fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 20000})
+	assertions.NoError(gotErr)
+	assertions.Equal(1, gotCount)
+}
+
+func Test_ClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trustedProxies := []*net.IPNet{proxies}
+
+	tests := map[string]struct {
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		"OK - direct": {
+			remoteAddr: "203.0.113.1:1234",
+			want:       "203.0.113.1",
+		},
+		"OK - forwarded by an untrusted proxy": {
+			remoteAddr: "203.0.113.1:1234",
+			forwarded:  []string{"198.51.100.1"},
+			want:       "203.0.113.1",
+		},
+		"OK - forwarded by a trusted proxy": {
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		"OK - forwarded by a chain of trusted proxies": {
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"192.0.2.1, 198.51.100.1, 10.0.0.2", "10.0.0.3"},
+			want:       "198.51.100.1",
+		},
+		"OK - forwarded by trusted proxies only": {
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"10.0.0.2"},
+			want:       "10.0.0.2",
+		},
+		"OK - malformed forwarded address": {
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"198.51.100.1, fizz, 10.0.0.2"},
+			want:       "10.0.0.2",
+		},
+		"OK - IPv6": {
+			remoteAddr: "[2001:db8::1]:1234",
+			want:       "2001:db8::1",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/fizzbuzz", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, forwarded := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", forwarded)
+			}
+			assert.Equal(t, tt.want, ClientIP(r, trustedProxies))
+		})
+	}
+}
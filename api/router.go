@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"fizzbuzz-server/api/clienterr"
+	"fizzbuzz-server/internal/stats"
+)
+
+// routeKey is the context key of the route matched by the router
+type routeKey struct{}
+
+// unmatchedRoute is the route of the requests to an unknown path, so they share their metrics
+const unmatchedRoute = "unmatched"
+
+// router dispatches the requests to the handler registered for their path and method
+// requests to a known path with another method are answered centrally with a 405 and the Allow header
+type router struct {
+	routes map[string]map[string]http.Handler
+	// wrap turns the process of the requests not dispatched to a handler into a handler,
+	// so they go through the same middlewares as the others
+	wrap func(ProcessFunc) http.Handler
+}
+
+func newRouter(wrap func(ProcessFunc) http.Handler) *router {
+	return &router{routes: make(map[string]map[string]http.Handler), wrap: wrap}
+}
+
+// handle registers the handler of this path for these methods
+// it panics if a method is already registered for the path, as http.ServeMux does
+func (rt *router) handle(path string, handler http.Handler, methods ...string) {
+	if rt.routes[path] == nil {
+		rt.routes[path] = make(map[string]http.Handler)
+	}
+	for _, method := range methods {
+		if _, exists := rt.routes[path][method]; exists {
+			panic(fmt.Sprintf("multiple registrations for %s %s", method, path))
+		}
+		rt.routes[path][method] = handler
+	}
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	methods, ok := rt.routes[r.URL.Path]
+	if !ok {
+		rt.wrap(processNotFound).ServeHTTP(w, withRoute(r, unmatchedRoute))
+		return
+	}
+	r = withRoute(r, r.URL.Path)
+	handler, ok := methods[r.Method]
+	if !ok {
+		rt.wrap(processMethodNotAllowed(methods)).ServeHTTP(w, r)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// withRoute records in the request context the route it was dispatched to
+func withRoute(r *http.Request, route string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, route))
+}
+
+// routeOf gives the route the request was dispatched to, or its path if it did not go through the router
+func routeOf(r *http.Request) string {
+	if route, ok := r.Context().Value(routeKey{}).(string); ok {
+		return route
+	}
+	return r.URL.Path
+}
+
+// processNotFound answers the requests to an unknown path
+func processNotFound(r *http.Request, _ stats.Counter) (int, map[string][]string, []byte, error) {
+	return http.StatusNotFound,
+		clienterr.Headers(),
+		clienterr.ClientError{Code: http.StatusNotFound, Desc: "not found"}.GetErrorBody(r.Context()),
+		errors.New("unknown path")
+}
+
+// processMethodNotAllowed creates the process answering the requests to a known path with another method
+func processMethodNotAllowed(methods map[string]http.Handler) ProcessFunc {
+	allowed := make([]string, 0, len(methods))
+	for method := range methods {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	allow := strings.Join(allowed, ", ")
+
+	return func(r *http.Request, _ stats.Counter) (int, map[string][]string, []byte, error) {
+		headers := clienterr.Headers()
+		headers["Allow"] = []string{allow}
+		return http.StatusMethodNotAllowed,
+			headers,
+			clienterr.ClientError{Code: http.StatusMethodNotAllowed, Desc: "method not allowed"}.GetErrorBody(r.Context()),
+			errors.New("invalid method")
+	}
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"fizzbuzz-server/api/docshandler"
+	"fizzbuzz-server/config"
+	"fizzbuzz-server/internal/stats"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_router(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		method      string
+		path        string
+		wantCode    int
+		wantAllow   string
+		wantBody    string
+		wantMetrics string
+	}{
+		"OK": {
+			method:      "GET",
+			path:        "/healthz",
+			wantCode:    http.StatusOK,
+			wantBody:    `{"status":"ok"}`,
+			wantMetrics: `fizzbuzz_http_requests_total{code="200",method="GET",route="/healthz"} 1`,
+		},
+		"KO - method not allowed": {
+			method:      "PUT",
+			path:        "/fizzbuzz",
+			wantCode:    http.StatusMethodNotAllowed,
+			wantAllow:   "GET, POST",
+			wantBody:    `{"code":405,"desc":"method not allowed"}`,
+			wantMetrics: `fizzbuzz_http_requests_total{code="405",method="PUT",route="/fizzbuzz"} 1`,
+		},
+		"KO - method not allowed on a GET route": {
+			method:      "DELETE",
+			path:        "/mostfreqreq",
+			wantCode:    http.StatusMethodNotAllowed,
+			wantAllow:   "GET",
+			wantBody:    `{"code":405,"desc":"method not allowed"}`,
+			wantMetrics: `fizzbuzz_http_requests_total{code="405",method="DELETE",route="/mostfreqreq"} 1`,
+		},
+		"KO - not found": {
+			method:      "GET",
+			path:        "/fizzbuzz/unknown",
+			wantCode:    http.StatusNotFound,
+			wantBody:    `{"code":404,"desc":"not found"}`,
+			wantMetrics: `fizzbuzz_http_requests_total{code="404",method="GET",route="unmatched"} 1`,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assertions := assert.New(t)
+
+			// each API has its own routes and metrics
+			api := Init(config.Conf{MiddlewareMetrics: true}, stats.NewFizzbuzzCounter())
+			rr := httptest.NewRecorder()
+			api.Handler.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+			assertions.Equal(tt.wantCode, rr.Code)
+			assertions.Equal(tt.wantAllow, rr.Header().Get("Allow"))
+			assertions.Equal("application/json", rr.Header().Get("Content-Type"))
+			assertions.Equal(tt.wantBody, rr.Body.String())
+
+			rr = httptest.NewRecorder()
+			api.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+			assertions.Contains(rr.Body.String(), tt.wantMetrics)
+		})
+	}
+}
+
+func Test_router_duplicateRegistration(t *testing.T) {
+	t.Parallel()
+
+	rt := newRouter(nil)
+	rt.handle("/fizzbuzz", http.NotFoundHandler(), "GET", "POST")
+	assert.Panics(t, func() {
+		rt.handle("/fizzbuzz", http.NotFoundHandler(), "POST")
+	})
+}
+
+func Test_Init_routesDocumented(t *testing.T) {
+	t.Parallel()
+	assertions := assert.New(t)
+
+	// every route is registered, whatever the middlewares enabled
+	api := Init(config.Conf{}, stats.NewFizzbuzzCounter())
+	rt, ok := api.Handler.(*router)
+	assertions.True(ok)
+	registered := []string{}
+	for path, methods := range rt.routes {
+		for method := range methods {
+			registered = append(registered, method+" "+path)
+		}
+	}
+
+	spec := struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}{}
+	assertions.NoError(json.Unmarshal(docshandler.Spec, &spec))
+	documented := []string{}
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	assertions.ElementsMatch(registered, documented, "the routes registered must be described in the OpenAPI document")
+}
+
+func Test_Init_contentTypesDocumented(t *testing.T) {
+	t.Parallel()
+
+	// the responses of each route have one of the types documented for their status code
+	type response struct {
+		Ref     string                     `json:"$ref"`
+		Content map[string]json.RawMessage `json:"content"`
+	}
+	spec := struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Responses map[string]response `json:"responses"`
+		} `json:"components"`
+	}{}
+	require.NoError(t, json.Unmarshal(docshandler.Spec, &spec))
+	documentedTypes := func(method, path string, code int) ([]string, bool) {
+		operation := struct {
+			Responses map[string]response `json:"responses"`
+		}{}
+		if errJson := json.Unmarshal(spec.Paths[path][strings.ToLower(method)], &operation); errJson != nil {
+			return nil, false
+		}
+		resp, ok := operation.Responses[strconv.Itoa(code)]
+		if resp.Ref != "" {
+			resp = spec.Components.Responses[strings.TrimPrefix(resp.Ref, "#/components/responses/")]
+		}
+		types := []string{}
+		for mediaType := range resp.Content {
+			types = append(types, mediaType)
+		}
+		return types, ok
+	}
+
+	conf := config.Conf{ParsedAPIKeys: []config.APIKey{{Name: "client1", Key: "k1"}}, RateLimitRate: 0.001, RateLimitBurst: 1000, RateLimitElementsPerToken: 1000}
+	api := Init(conf, stats.NewFizzbuzzCounter())
+	conf.RateLimitBurst = 1
+	rateLimited := Init(conf, stats.NewFizzbuzzCounter())
+	validRules := `{"rules":[{"divisor":3,"word":"fizz"}],"limit":15}`
+	tests := map[string]struct {
+		api      *Api
+		method   string
+		target   string
+		body     string
+		apiKey   string
+		accept   string
+		wantCode int
+	}{
+		"fizzbuzz":                {method: "GET", target: "/fizzbuzz?int1=3&int2=5&limit=15&str1=fizz&str2=buzz", apiKey: "k1", wantCode: http.StatusOK},
+		"fizzbuzz csv":            {method: "GET", target: "/fizzbuzz?int1=3&int2=5&limit=15&str1=fizz&str2=buzz&format=csv", apiKey: "k1", wantCode: http.StatusOK},
+		"fizzbuzz invalid":        {method: "GET", target: "/fizzbuzz", apiKey: "k1", wantCode: http.StatusBadRequest},
+		"fizzbuzz not acceptable": {method: "GET", target: "/fizzbuzz?int1=3&int2=5&limit=15", apiKey: "k1", accept: "image/png", wantCode: http.StatusNotAcceptable},
+		"fizzbuzz unauthorized":   {method: "GET", target: "/fizzbuzz", wantCode: http.StatusUnauthorized},
+		"v2 fizzbuzz":             {method: "POST", target: "/v2/fizzbuzz", body: validRules, apiKey: "k1", wantCode: http.StatusOK},
+		"v2 fizzbuzz invalid":     {method: "POST", target: "/v2/fizzbuzz", body: `{}`, apiKey: "k1", wantCode: http.StatusBadRequest},
+		"mostfreqreq":             {method: "GET", target: "/mostfreqreq", apiKey: "k1", wantCode: http.StatusOK},
+		"topreq":                  {method: "GET", target: "/topreq", apiKey: "k1", wantCode: http.StatusOK},
+		"topreq invalid":          {method: "GET", target: "/topreq?k=fizz", apiKey: "k1", wantCode: http.StatusBadRequest},
+		"v2 mostfreqreq":          {method: "GET", target: "/v2/mostfreqreq", apiKey: "k1", wantCode: http.StatusOK},
+		"metrics":                 {method: "GET", target: "/metrics", wantCode: http.StatusOK},
+		"healthz":                 {method: "GET", target: "/healthz", wantCode: http.StatusOK},
+		"readyz":                  {method: "GET", target: "/readyz", wantCode: http.StatusOK},
+		"openapi":                 {method: "GET", target: "/openapi.json", wantCode: http.StatusOK},
+		"docs":                    {method: "GET", target: "/docs", wantCode: http.StatusOK},
+		"swagger ui css":          {method: "GET", target: "/docs/swagger-ui.css", wantCode: http.StatusOK},
+		"swagger ui bundle":       {method: "GET", target: "/docs/swagger-ui-bundle.js", wantCode: http.StatusOK},
+		"too many requests":       {api: rateLimited, method: "GET", target: "/mostfreqreq", apiKey: "k1", wantCode: http.StatusTooManyRequests},
+	}
+	// the only token of the client is taken beforehand
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/mostfreqreq", nil)
+	r.Header.Set("X-API-Key", "k1")
+	rateLimited.Handler.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assertions := assert.New(t)
+
+			server := api
+			if tt.api != nil {
+				server = tt.api
+			}
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.apiKey != "" {
+				r.Header.Set("X-API-Key", tt.apiKey)
+			}
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			rr := httptest.NewRecorder()
+			server.Handler.ServeHTTP(rr, r)
+			assertions.Equal(tt.wantCode, rr.Code)
+
+			path := strings.SplitN(tt.target, "?", 2)[0]
+			types, ok := documentedTypes(tt.method, path, rr.Code)
+			if assertions.True(ok, "status code %d of %s %s not documented", rr.Code, tt.method, path) {
+				mediaType, _, errParse := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+				assertions.NoError(errParse)
+				assertions.Contains(types, mediaType, "type of the %d response of %s %s not documented", rr.Code, tt.method, path)
+			}
+		})
+	}
+}
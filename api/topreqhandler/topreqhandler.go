@@ -0,0 +1,73 @@
+package topreqhandler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"fizzbuzz-server/api/clienterr"
+	"fizzbuzz-server/internal/stats"
+)
+
+const (
+	defaultK = 10
+	maxK     = 100
+)
+
+// ProcessTopReq does all the process of a topreq request
+func ProcessTopReq(r *http.Request, counter stats.Counter) (int, map[string][]string, []byte, error) {
+	// retrieve and check params
+	k, clientErr, errParams := getParamsTopReq(r)
+	if errParams != nil {
+		return http.StatusBadRequest,
+			clienterr.Headers(),
+			clientErr.GetErrorBody(r.Context()),
+			fmt.Errorf("invalid params: %w", errParams)
+	}
+
+	// retrieve top requests
+	topReq, errCounter := counter.TopK(r.Context(), k)
+	if errCounter != nil {
+		return http.StatusInternalServerError,
+			clienterr.Headers(),
+			clienterr.InternalError.GetErrorBody(r.Context()),
+			fmt.Errorf("error retrieving top requests: %w", errCounter)
+	}
+
+	// create response
+	body, errJson := json.Marshal(topReq)
+	if errJson != nil {
+		return http.StatusInternalServerError,
+			clienterr.Headers(),
+			clienterr.InternalError.GetErrorBody(r.Context()),
+			fmt.Errorf("error marshalling json: %w", errJson)
+	}
+	return http.StatusOK,
+		map[string][]string{"Content-Type": {"application/json"}},
+		body,
+		nil
+}
+
+// getParamsTopReq retrieves and checks the 'k' query parameter, defaulting to defaultK
+// it returns two versions of the error if needed, one for the client and one more precise for internal use
+func getParamsTopReq(r *http.Request) (int, clienterr.ClientError, error) {
+	kStr := r.URL.Query().Get("k")
+	if kStr == "" {
+		return defaultK, clienterr.ClientError{}, nil
+	}
+
+	k, errConv := strconv.Atoi(kStr)
+	if errConv != nil {
+		return 0,
+			clienterr.ClientError{Code: http.StatusBadRequest, Desc: "k must be an integer"},
+			fmt.Errorf("converting k: %w", errConv)
+	}
+	if k < 1 || k > maxK {
+		errStr := fmt.Sprintf("k must be between 1 and %d", maxK)
+		return 0, clienterr.ClientError{Code: http.StatusBadRequest, Desc: errStr}, errors.New(errStr)
+	}
+
+	return k, clienterr.ClientError{}, nil
+}
@@ -0,0 +1,102 @@
+package topreqhandler
+
+import (
+	"context"
+	"fizzbuzz-server/internal/fizzbuzz"
+	"fizzbuzz-server/internal/stats"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ProcessTopReq(t *testing.T) {
+	counts := map[fizzbuzz.Params]int{
+		{Int1: 3, Int2: 4, Limit: 12, Str1: "fizz", Str2: "buzz"}: 1,
+		{Int1: 1, Int2: 2, Limit: 12, Str1: "fizz", Str2: "buzz"}: 3,
+		{Int1: 2, Int2: 2, Limit: 12, Str1: "fizz", Str2: "buzz"}: 1,
+	}
+
+	tests := map[string]struct {
+		req         *http.Request
+		counter     stats.Counter
+		wantCode    int
+		wantHeaders map[string][]string
+		wantBody    []byte
+		wantErrStr  string
+	}{
+		"OK": {
+			req: &http.Request{
+				Method: "GET",
+				URL:    &url.URL{RawQuery: "k=2"},
+			},
+			counter:     newCounter(counts),
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}},
+			wantBody: []byte(`[{"count":3,"params":{"int1":1,"int2":2,"limit":12,"str1":"fizz","str2":"buzz"}},` +
+				`{"count":1,"params":{"int1":2,"int2":2,"limit":12,"str1":"fizz","str2":"buzz"}}]`),
+		},
+		"OK - default k": {
+			req: &http.Request{
+				Method: "GET",
+				URL:    &url.URL{},
+			},
+			counter:     newCounter(counts),
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}},
+			wantBody: []byte(`[{"count":3,"params":{"int1":1,"int2":2,"limit":12,"str1":"fizz","str2":"buzz"}},` +
+				`{"count":1,"params":{"int1":2,"int2":2,"limit":12,"str1":"fizz","str2":"buzz"}},` +
+				`{"count":1,"params":{"int1":3,"int2":4,"limit":12,"str1":"fizz","str2":"buzz"}}]`),
+		},
+		"KO - k not an integer": {
+			req: &http.Request{
+				Method: "GET",
+				URL:    &url.URL{RawQuery: "k=abc"},
+			},
+			counter:     newCounter(counts),
+			wantCode:    http.StatusBadRequest,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}},
+			wantBody:    []byte(`{"code":400,"desc":"k must be an integer"}`),
+			wantErrStr:  "invalid params",
+		},
+		"KO - k out of range": {
+			req: &http.Request{
+				Method: "GET",
+				URL:    &url.URL{RawQuery: "k=0"},
+			},
+			counter:     newCounter(counts),
+			wantCode:    http.StatusBadRequest,
+			wantHeaders: map[string][]string{"Content-Type": {"application/json"}},
+			wantBody:    []byte(`{"code":400,"desc":"k must be between 1 and 100"}`),
+			wantErrStr:  "invalid params",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			gotCode, gotHeaders, gotBody, gotErr := ProcessTopReq(tt.req, tt.counter)
+
+			if tt.wantErrStr != "" {
+				assertions.Contains(gotErr.Error(), tt.wantErrStr)
+			} else {
+				assertions.NoError(gotErr)
+			}
+			assertions.Equal(tt.wantCode, gotCode)
+			assertions.Equal(tt.wantHeaders, gotHeaders)
+			assertions.Equal(tt.wantBody, gotBody)
+		})
+	}
+}
+
+// newCounter creates a counter already incremented with these counts
+func newCounter(counts map[fizzbuzz.Params]int) stats.Counter {
+	counter := stats.NewFizzbuzzCounter()
+	for params, count := range counts {
+		for i := 0; i < count; i++ {
+			counter.Inc(context.Background(), params)
+		}
+	}
+	return counter
+}
@@ -1,15 +1,91 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
 
 	env "github.com/Netflix/go-env"
 )
 
+// Available values for COUNTER_BACKEND
+const (
+	CounterBackendMemory = "memory"
+	CounterBackendRedis  = "redis"
+)
+
+// APIKey is a key allowed to call the API
+type APIKey struct {
+	// Name identifies the key in the logs and the statistics, so the key itself is never stored
+	Name  string
+	Key   string
+	Admin bool
+}
+
+// validKeyName checks the name of an API key can be used in storage keys
+var validKeyName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 // Conf contains the program configuration
 type Conf struct {
-	Port     int    `env:"PORT,default=8080"`
-	LogLevel string `env:"LOG_LEVEL,default=info"`
+	Port            int           `env:"PORT,default=8080"`
+	LogLevel        string        `env:"LOG_LEVEL,default=info"`
+	MaxLimit        int           `env:"MAX_LIMIT,default=1000000"`
+	MaxStreamLimit  int           `env:"MAX_STREAM_LIMIT,default=100000000"`
+	MaxBodyBytes    int64         `env:"MAX_BODY_BYTES,default=1048576"`
+	CounterBackend  string        `env:"COUNTER_BACKEND,default=memory"`
+	StatsDir        string        `env:"STATS_DIR"`
+	RedisAddr       string        `env:"REDIS_ADDR,default=localhost:6379"`
+	RedisPassword   string        `env:"REDIS_PASSWORD"`
+	RedisDB         int           `env:"REDIS_DB,default=0"`
+	RedisPrefix     string        `env:"REDIS_PREFIX,default=fizzbuzz"`
+	DrainDelay      time.Duration `env:"DRAIN_DELAY,default=2s"`
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT,default=5s"`
+	// server timeouts, zero meaning no timeout
+	ReadHeaderTimeout time.Duration `env:"READ_HEADER_TIMEOUT,default=5s"`
+	ReadTimeout       time.Duration `env:"READ_TIMEOUT,default=10s"`
+	WriteTimeout      time.Duration `env:"WRITE_TIMEOUT,default=60s"`
+	IdleTimeout       time.Duration `env:"IDLE_TIMEOUT,default=120s"`
+	// RequestTimeout is the deadline of the processing of each request, zero meaning no deadline
+	RequestTimeout time.Duration `env:"REQUEST_TIMEOUT,default=30s"`
+	// StreamTimeout is the deadline of the processing and the sending of each streamed response, instead of
+	// RequestTimeout and WriteTimeout, zero meaning no deadline
+	StreamTimeout time.Duration `env:"STREAM_TIMEOUT,default=1h"`
+	// rate limiting of each client, disabled if the rate is zero
+	RateLimitRate             float64 `env:"RATE_LIMIT_RATE,default=10"`
+	RateLimitBurst            int     `env:"RATE_LIMIT_BURST,default=100"`
+	RateLimitElementsPerToken int     `env:"RATE_LIMIT_ELEMENTS_PER_TOKEN,default=10000"`
+	// TrustedProxies are the comma separated IPs or CIDRs of the proxies whose X-Forwarded-For header is trusted
+	TrustedProxies string `env:"TRUSTED_PROXIES"`
+	// TrustedProxyNets are the parsed TrustedProxies, set by InitEnvConf
+	TrustedProxyNets []*net.IPNet
+	// API keys, the authentication is enabled if any is set
+	// each key is written 'name:key', or 'name:key:admin' for the keys seeing the global statistics
+	APIKeys     string `env:"API_KEYS"`
+	APIKeysFile string `env:"API_KEYS_FILE"`
+	// ParsedAPIKeys are the keys of APIKeys then APIKeysFile, set by InitEnvConf
+	ParsedAPIKeys []APIKey
+	// CacheMaxAge is how long the clients and the caches can keep a fizzbuzz output
+	CacheMaxAge time.Duration `env:"CACHE_MAX_AGE,default=1h"`
+	// in-process cache of the fizzbuzz responses, bounded by their total size in bytes, disabled if the size is zero
+	// it is pre-warmed in the background at startup with the responses of the most frequent requests, none if zero,
+	// the pre-warming being stopped past its timeout, never if zero
+	ResponseCacheBytes          int64         `env:"RESPONSE_CACHE_BYTES,default=67108864"`
+	ResponseCachePrewarm        int           `env:"RESPONSE_CACHE_PREWARM,default=0"`
+	ResponseCachePrewarmTimeout time.Duration `env:"RESPONSE_CACHE_PREWARM_TIMEOUT,default=30s"`
+	// compression of the responses, those smaller than the minimum size being sent as is
+	// the level goes from 1 (fastest) to 9 (smallest)
+	CompressionMinSize int `env:"COMPRESSION_MIN_SIZE,default=1024"`
+	CompressionLevel   int `env:"COMPRESSION_LEVEL,default=6"`
+	// each middleware can be disabled
+	MiddlewareRequestID   bool `env:"MIDDLEWARE_REQUEST_ID,default=true"`
+	MiddlewareLogs        bool `env:"MIDDLEWARE_LOGS,default=true"`
+	MiddlewareMetrics     bool `env:"MIDDLEWARE_METRICS,default=true"`
+	MiddlewareCompression bool `env:"MIDDLEWARE_COMPRESSION,default=true"`
+	MiddlewareRecovery    bool `env:"MIDDLEWARE_RECOVERY,default=true"`
 }
 
 // InitEnvConf initiate a Conf struct using env vars
@@ -20,5 +96,130 @@ func InitEnvConf() (Conf, error) {
 		return conf, fmt.Errorf("loading env vars: %w", envErr)
 	}
 
+	if conf.CounterBackend != CounterBackendMemory && conf.CounterBackend != CounterBackendRedis {
+		return conf, fmt.Errorf("unknown counter backend %q", conf.CounterBackend)
+	}
+	if conf.ShutdownTimeout <= 0 {
+		return conf, fmt.Errorf("shutdown timeout must be positive, got %s", conf.ShutdownTimeout)
+	}
+	if conf.DrainDelay < 0 || conf.DrainDelay >= conf.ShutdownTimeout {
+		return conf, fmt.Errorf("drain delay must not be negative and be shorter than the shutdown timeout, got %s", conf.DrainDelay)
+	}
+	if conf.ReadHeaderTimeout < 0 || conf.ReadTimeout < 0 || conf.WriteTimeout < 0 || conf.IdleTimeout < 0 || conf.RequestTimeout < 0 || conf.StreamTimeout < 0 || conf.ResponseCachePrewarmTimeout < 0 {
+		return conf, errors.New("timeouts must not be negative")
+	}
+	// past the write timeout the response can't be sent anymore, the request must time out before
+	if conf.WriteTimeout > 0 && (conf.RequestTimeout == 0 || conf.RequestTimeout >= conf.WriteTimeout) {
+		return conf, fmt.Errorf("request timeout must be set and shorter than the write timeout, got %s", conf.RequestTimeout)
+	}
+
+	if conf.RateLimitRate < 0 {
+		return conf, fmt.Errorf("rate limit rate must not be negative, got %v", conf.RateLimitRate)
+	}
+	if conf.RateLimitRate > 0 && (conf.RateLimitBurst < 1 || conf.RateLimitElementsPerToken < 1) {
+		return conf, errors.New("rate limit burst and elements per token must be positive")
+	}
+	if conf.CacheMaxAge < 0 {
+		return conf, fmt.Errorf("cache max age must not be negative, got %s", conf.CacheMaxAge)
+	}
+	if conf.ResponseCacheBytes < 0 {
+		return conf, fmt.Errorf("response cache bytes must not be negative, got %d", conf.ResponseCacheBytes)
+	}
+	if conf.ResponseCachePrewarm < 0 {
+		return conf, fmt.Errorf("response cache prewarm must not be negative, got %d", conf.ResponseCachePrewarm)
+	}
+	if conf.CompressionMinSize < 0 {
+		return conf, fmt.Errorf("compression min size must not be negative, got %d", conf.CompressionMinSize)
+	}
+	if conf.CompressionLevel < 1 || conf.CompressionLevel > 9 {
+		return conf, fmt.Errorf("compression level must be between 1 and 9, got %d", conf.CompressionLevel)
+	}
+
+	trustedProxyNets, errProxies := parseTrustedProxies(conf.TrustedProxies)
+	if errProxies != nil {
+		return conf, fmt.Errorf("parsing trusted proxies: %w", errProxies)
+	}
+	conf.TrustedProxyNets = trustedProxyNets
+
+	apiKeys, errKeys := loadAPIKeys(conf.APIKeys, conf.APIKeysFile)
+	if errKeys != nil {
+		return conf, fmt.Errorf("loading API keys: %w", errKeys)
+	}
+	conf.ParsedAPIKeys = apiKeys
+
 	return conf, nil
 }
+
+// loadAPIKeys parses the comma separated API keys, then the ones of the file, one per line
+// blank lines and lines starting with '#' are ignored in the file
+func loadAPIKeys(keys string, file string) ([]APIKey, error) {
+	entries := strings.Split(keys, ",")
+	if file != "" {
+		content, errRead := os.ReadFile(file)
+		if errRead != nil {
+			return nil, fmt.Errorf("reading file: %w", errRead)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+				entries = append(entries, line)
+			}
+		}
+	}
+
+	var apiKeys []APIKey
+	seenNames, seenKeys := make(map[string]bool), make(map[string]bool)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.Split(entry, ":")
+		if len(fields) < 2 || len(fields) > 3 || (len(fields) == 3 && fields[2] != "admin") {
+			return nil, errors.New("keys must be written 'name:key' or 'name:key:admin'")
+		}
+		apiKey := APIKey{Name: fields[0], Key: fields[1], Admin: len(fields) == 3}
+		if !validKeyName.MatchString(apiKey.Name) {
+			return nil, fmt.Errorf("invalid key name %q, only letters, digits, '_' and '-' are allowed", apiKey.Name)
+		}
+		if apiKey.Key == "" {
+			return nil, fmt.Errorf("empty key for %q", apiKey.Name)
+		}
+		if seenNames[apiKey.Name] {
+			return nil, fmt.Errorf("duplicate key name %q", apiKey.Name)
+		}
+		if seenKeys[apiKey.Key] {
+			return nil, fmt.Errorf("key of %q already used", apiKey.Name)
+		}
+		seenNames[apiKey.Name], seenKeys[apiKey.Key] = true, true
+		apiKeys = append(apiKeys, apiKey)
+	}
+	return apiKeys, nil
+}
+
+// parseTrustedProxies parses a comma separated list of IPs or CIDRs
+func parseTrustedProxies(proxies string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, proxy := range strings.Split(proxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", proxy)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, ipNet, errParse := net.ParseCIDR(proxy)
+		if errParse != nil {
+			return nil, errParse
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseTrustedProxies(t *testing.T) {
+	tests := map[string]struct {
+		proxies       string
+		wantTrusted   []string
+		wantUntrusted []string
+		wantErr       bool
+	}{
+		"OK - none": {
+			proxies:       "",
+			wantUntrusted: []string{"10.0.0.1"},
+		},
+		"OK - IPs and CIDRs": {
+			proxies:       "10.0.0.1, 192.168.0.0/16,::1",
+			wantTrusted:   []string{"10.0.0.1", "192.168.3.4", "::1"},
+			wantUntrusted: []string{"10.0.0.2", "172.16.0.1", "::2"},
+		},
+		"KO - invalid IP": {
+			proxies: "10.0.0.1,proxy",
+			wantErr: true,
+		},
+		"KO - invalid CIDR": {
+			proxies: "10.0.0.0/33",
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			got, gotErr := parseTrustedProxies(tt.proxies)
+			if tt.wantErr {
+				assertions.Error(gotErr)
+				return
+			}
+			assertions.NoError(gotErr)
+			contains := func(ip string) bool {
+				for _, ipNet := range got {
+					if ipNet.Contains(net.ParseIP(ip)) {
+						return true
+					}
+				}
+				return false
+			}
+			for _, ip := range tt.wantTrusted {
+				assertions.True(contains(ip), ip)
+			}
+			for _, ip := range tt.wantUntrusted {
+				assertions.False(contains(ip), ip)
+			}
+		})
+	}
+}
+
+func Test_loadAPIKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys")
+	errWrite := os.WriteFile(file, []byte("# keys of the clients\nclient-2:k2\n\n  admin:k3:admin\n"), 0o600)
+	assert.NoError(t, errWrite)
+
+	tests := map[string]struct {
+		keys    string
+		file    string
+		want    []APIKey
+		wantErr bool
+	}{
+		"OK - none": {
+			want: nil,
+		},
+		"OK - env": {
+			keys: "client_1:k1, root:k0:admin",
+			want: []APIKey{{Name: "client_1", Key: "k1"}, {Name: "root", Key: "k0", Admin: true}},
+		},
+		"OK - env and file": {
+			keys: "client_1:k1",
+			file: file,
+			want: []APIKey{{Name: "client_1", Key: "k1"}, {Name: "client-2", Key: "k2"}, {Name: "admin", Key: "k3", Admin: true}},
+		},
+		"KO - missing file": {
+			file:    filepath.Join(t.TempDir(), "missing"),
+			wantErr: true,
+		},
+		"KO - missing key": {
+			keys:    "client_1",
+			wantErr: true,
+		},
+		"KO - empty key": {
+			keys:    "client_1:",
+			wantErr: true,
+		},
+		"KO - unknown role": {
+			keys:    "client_1:k1:root",
+			wantErr: true,
+		},
+		"KO - invalid name": {
+			keys:    "client 1:k1",
+			wantErr: true,
+		},
+		"KO - duplicate name": {
+			keys:    "client_1:k1,client_1:k2",
+			wantErr: true,
+		},
+		"KO - duplicate key": {
+			keys:    "client_1:k1,client_2:k1",
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			got, gotErr := loadAPIKeys(tt.keys, tt.file)
+			if tt.wantErr {
+				assertions.Error(gotErr)
+				return
+			}
+			assertions.NoError(gotErr)
+			assertions.Equal(tt.want, got)
+		})
+	}
+}
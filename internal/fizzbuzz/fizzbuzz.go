@@ -1,10 +1,20 @@
 package fizzbuzz
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"strconv"
 )
 
+// ctxCheckInterval is the number of elements generated between two checks of the context,
+// checking it costing more than generating an element
+const ctxCheckInterval = 1024
+
+// maxPrealloc is the greatest number of elements the output is allocated for upfront,
+// so a process aborted early does not allocate for its whole limit
+const maxPrealloc = 64 * 1024
+
 // Params are the required parameters for the fizzbuzz process
 type Params struct {
 	Int1  int    `json:"int1"`
@@ -14,27 +24,187 @@ type Params struct {
 	Str2  string `json:"str2"`
 }
 
-// ExecFizzbuzz starts the fizzbuzz process
-func ExecFizzbuzz(params Params) ([]string, error) {
-	var output []string
+// Rule replaces the numbers that are multiples of Divisor by Word
+type Rule struct {
+	Divisor int    `json:"divisor"`
+	Word    string `json:"word"`
+}
+
+// RuleSet are the parameters of the generalized fizzbuzz process
+// the words of all the rules matching a number are concatenated in the order of the rules
+type RuleSet struct {
+	Rules []Rule `json:"rules"`
+	Limit int    `json:"limit"`
+}
+
+// Page is a window of the fizzbuzz output: the Count elements following the Offset first ones
+type Page struct {
+	Offset int `json:"offset"`
+	Count  int `json:"count"`
+}
+
+// RuleSet gives the rule set equivalent to these parameters
+func (p Params) RuleSet() RuleSet {
+	return RuleSet{
+		Rules: []Rule{
+			{Divisor: p.Int1, Word: p.Str1},
+			{Divisor: p.Int2, Word: p.Str2},
+		},
+		Limit: p.Limit,
+	}
+}
+
+// Key gives a canonical representation of the rule set, two rule sets are equal if their keys are equal
+func (rs RuleSet) Key() string {
+	// encoding a struct of slices, ints and strings can not fail and is deterministic
+	key, _ := json.Marshal(rs)
+	return string(key)
+}
+
+// Next gives the page following this one, ok is false if this page reaches the limit
+func (p Page) Next(limit int) (next Page, ok bool) {
+	if p.Count >= limit-p.Offset {
+		return Page{}, false
+	}
+	return Page{Offset: p.Offset + p.Count, Count: p.Count}, true
+}
+
+// Size gives the number of elements of this page of an output up to the limit, zero if the page is after the limit
+func (p Page) Size(limit int) int {
+	if p.Offset >= limit {
+		return 0
+	}
+	// compare to the remaining elements rather than adding to the offset, which could overflow
+	if p.Count < limit-p.Offset {
+		return p.Count
+	}
+	return limit - p.Offset
+}
+
+// Generator produces the fizzbuzz output one element at a time, so it can be streamed without keeping it in memory
+// it stops early once its context is done, Err giving the reason
+type Generator struct {
+	ctx   context.Context
+	rules []Rule
+	limit int
+	i     int
+	// untilCheck is the number of elements to generate before checking the context again
+	untilCheck int
+	err        error
+}
+
+// NewGenerator creates a generator for these parameters
+func NewGenerator(ctx context.Context, params Params) (*Generator, error) {
 	if params.Int1 == 0 || params.Int2 == 0 || params.Limit < 1 {
-		return []string{}, errors.New("invalid params")
+		return nil, errors.New("invalid params")
 	}
-	for i := 1; i <= params.Limit; i++ {
-		str := ""
-		empty := true
-		if i%params.Int1 == 0 {
-			str = params.Str1
-			empty = false
+	return NewRulesGenerator(ctx, params.RuleSet())
+}
+
+// NewRulesGenerator creates a generator for this rule set
+func NewRulesGenerator(ctx context.Context, ruleSet RuleSet) (*Generator, error) {
+	return NewPageGenerator(ctx, ruleSet, Page{Offset: 0, Count: ruleSet.Limit})
+}
+
+// NewPageGenerator creates a generator for this page of the rule set output
+// the elements before the page are not computed, and the page stops at the limit of the rule set
+func NewPageGenerator(ctx context.Context, ruleSet RuleSet, page Page) (*Generator, error) {
+	if len(ruleSet.Rules) == 0 || ruleSet.Limit < 1 {
+		return nil, errors.New("invalid rule set")
+	}
+	for _, rule := range ruleSet.Rules {
+		if rule.Divisor == 0 {
+			return nil, errors.New("invalid rule set")
 		}
-		if i%params.Int2 == 0 {
-			str = str + params.Str2
-			empty = false
+	}
+	if page.Offset < 0 || page.Count < 1 {
+		return nil, errors.New("invalid page")
+	}
+
+	// compare to the remaining elements rather than adding to the offset, which could overflow
+	end := ruleSet.Limit
+	if page.Offset >= ruleSet.Limit {
+		end = page.Offset
+	} else if page.Count < ruleSet.Limit-page.Offset {
+		end = page.Offset + page.Count
+	}
+	rules := make([]Rule, len(ruleSet.Rules))
+	copy(rules, ruleSet.Rules)
+	return &Generator{ctx: ctx, rules: rules, limit: end, i: page.Offset}, nil
+}
+
+// Next returns the next element of the output, ok is false once the limit is reached or the context is done
+func (g *Generator) Next() (str string, ok bool) {
+	if g.err != nil || g.i >= g.limit {
+		return "", false
+	}
+	if g.untilCheck--; g.untilCheck < 0 {
+		if g.err = g.ctx.Err(); g.err != nil {
+			return "", false
 		}
-		if empty {
-			str = strconv.Itoa(i)
+		g.untilCheck = ctxCheckInterval - 1
+	}
+	g.i++
+
+	empty := true
+	for _, rule := range g.rules {
+		if g.i%rule.Divisor == 0 {
+			str = str + rule.Word
+			empty = false
 		}
+	}
+	if empty {
+		str = strconv.Itoa(g.i)
+	}
+	return str, true
+}
+
+// Err gives the error of the context that stopped the generator, nil if it was not stopped
+func (g *Generator) Err() error {
+	return g.err
+}
+
+// ExecFizzbuzz starts the fizzbuzz process
+// it is aborted with the error of the context once the context is done
+func ExecFizzbuzz(ctx context.Context, params Params) ([]string, error) {
+	gen, errGen := NewGenerator(ctx, params)
+	if errGen != nil {
+		return []string{}, errGen
+	}
+	return collect(gen, params.Limit)
+}
+
+// ExecRules starts the generalized fizzbuzz process
+// it is aborted with the error of the context once the context is done
+func ExecRules(ctx context.Context, ruleSet RuleSet) ([]string, error) {
+	gen, errGen := NewRulesGenerator(ctx, ruleSet)
+	if errGen != nil {
+		return []string{}, errGen
+	}
+	return collect(gen, ruleSet.Limit)
+}
+
+// ExecPage starts the generalized fizzbuzz process on a page of the output only
+// it is aborted with the error of the context once the context is done
+func ExecPage(ctx context.Context, ruleSet RuleSet, page Page) ([]string, error) {
+	gen, errGen := NewPageGenerator(ctx, ruleSet, page)
+	if errGen != nil {
+		return []string{}, errGen
+	}
+	return collect(gen, gen.limit-gen.i)
+}
+
+// collect retrieves the whole output of the generator, or the error that stopped it
+func collect(gen *Generator, limit int) ([]string, error) {
+	if limit > maxPrealloc {
+		limit = maxPrealloc
+	}
+	output := make([]string, 0, limit)
+	for str, ok := gen.Next(); ok; str, ok = gen.Next() {
 		output = append(output, str)
 	}
+	if errGen := gen.Err(); errGen != nil {
+		return []string{}, errGen
+	}
 	return output, nil
 }
@@ -1,7 +1,10 @@
 package fizzbuzz
 
 import (
+	"context"
+	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -78,7 +81,7 @@ func Test_ExecFizzbuzz(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			assertions := assert.New(t)
 
-			got, gotErr := ExecFizzbuzz(tt.params)
+			got, gotErr := ExecFizzbuzz(context.Background(), tt.params)
 			if tt.wantErr {
 				assertions.Error(gotErr)
 			} else {
@@ -88,3 +91,287 @@ func Test_ExecFizzbuzz(t *testing.T) {
 		})
 	}
 }
+
+func Test_Generator(t *testing.T) {
+	tests := map[string]struct {
+		params  Params
+		want    []string
+		wantErr bool
+	}{
+		"KO - invalid limit": {
+			params:  Params{Int1: 3, Int2: 5, Limit: 0, Str1: "fizz", Str2: "buzz"},
+			wantErr: true,
+		},
+		"OK": {
+			params: Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"},
+			want: []string{
+				"1", "2", "fizz", "4", "buzz", "fizz", "7", "8",
+				"fizz", "buzz", "11", "fizz", "13", "14", "fizzbuzz", "16",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			gen, gotErr := NewGenerator(context.Background(), tt.params)
+			if tt.wantErr {
+				assertions.Error(gotErr)
+				return
+			}
+			assertions.NoError(gotErr)
+
+			got := []string{}
+			for str, ok := gen.Next(); ok; str, ok = gen.Next() {
+				got = append(got, str)
+			}
+			assertions.Equal(tt.want, got)
+
+			// the generator stays exhausted
+			_, ok := gen.Next()
+			assertions.False(ok)
+		})
+	}
+}
+
+func Test_ExecRules(t *testing.T) {
+	tests := map[string]struct {
+		ruleSet RuleSet
+		want    []string
+		wantErr bool
+	}{
+		"KO - no rules": {
+			ruleSet: RuleSet{Rules: []Rule{}, Limit: 16},
+			want:    []string{},
+			wantErr: true,
+		},
+		"KO - divisor = 0": {
+			ruleSet: RuleSet{Rules: []Rule{{Divisor: 3, Word: "fizz"}, {Divisor: 0, Word: "buzz"}}, Limit: 16},
+			want:    []string{},
+			wantErr: true,
+		},
+		"KO - invalid limit": {
+			ruleSet: RuleSet{Rules: []Rule{{Divisor: 3, Word: "fizz"}}, Limit: 0},
+			want:    []string{},
+			wantErr: true,
+		},
+		"OK - one rule": {
+			ruleSet: RuleSet{Rules: []Rule{{Divisor: 2, Word: "even"}}, Limit: 5},
+			want:    []string{"1", "even", "3", "even", "5"},
+		},
+		"OK - three rules": {
+			ruleSet: RuleSet{
+				Rules: []Rule{{Divisor: 3, Word: "fizz"}, {Divisor: 5, Word: "buzz"}, {Divisor: 7, Word: "bazz"}},
+				Limit: 21,
+			},
+			want: []string{
+				"1", "2", "fizz", "4", "buzz", "fizz", "bazz", "8", "fizz", "buzz", "11",
+				"fizz", "13", "bazz", "fizzbuzz", "16", "17", "fizz", "19", "buzz", "fizzbazz",
+			},
+		},
+		"OK - words concatenated in rules order": {
+			ruleSet: RuleSet{Rules: []Rule{{Divisor: 5, Word: "buzz"}, {Divisor: 3, Word: "fizz"}}, Limit: 15},
+			want: []string{
+				"1", "2", "fizz", "4", "buzz", "fizz", "7", "8",
+				"fizz", "buzz", "11", "fizz", "13", "14", "buzzfizz",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			got, gotErr := ExecRules(context.Background(), tt.ruleSet)
+			if tt.wantErr {
+				assertions.Error(gotErr)
+			} else {
+				assertions.NoError(gotErr)
+			}
+			assertions.Equal(tt.want, got)
+		})
+	}
+}
+
+func Test_RuleSet_Key(t *testing.T) {
+	assertions := assert.New(t)
+
+	params := Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	assertions.Equal(
+		`{"rules":[{"divisor":3,"word":"fizz"},{"divisor":5,"word":"buzz"}],"limit":16}`,
+		params.RuleSet().Key(),
+	)
+	assertions.NotEqual(
+		RuleSet{Rules: []Rule{{Divisor: 3, Word: "fizz"}, {Divisor: 5, Word: "buzz"}}, Limit: 16}.Key(),
+		RuleSet{Rules: []Rule{{Divisor: 5, Word: "buzz"}, {Divisor: 3, Word: "fizz"}}, Limit: 16}.Key(),
+		"rules order must matter",
+	)
+}
+
+func Test_ExecPage(t *testing.T) {
+	fizzbuzzRules := []Rule{{Divisor: 3, Word: "fizz"}, {Divisor: 5, Word: "buzz"}}
+
+	tests := map[string]struct {
+		ruleSet RuleSet
+		page    Page
+		want    []string
+		wantErr bool
+	}{
+		"KO - negative offset": {
+			ruleSet: RuleSet{Rules: fizzbuzzRules, Limit: 16},
+			page:    Page{Offset: -1, Count: 5},
+			want:    []string{},
+			wantErr: true,
+		},
+		"KO - invalid count": {
+			ruleSet: RuleSet{Rules: fizzbuzzRules, Limit: 16},
+			page:    Page{Offset: 0, Count: 0},
+			want:    []string{},
+			wantErr: true,
+		},
+		"KO - invalid rule set": {
+			ruleSet: RuleSet{Rules: []Rule{}, Limit: 16},
+			page:    Page{Offset: 0, Count: 5},
+			want:    []string{},
+			wantErr: true,
+		},
+		"OK - first page": {
+			ruleSet: RuleSet{Rules: fizzbuzzRules, Limit: 16},
+			page:    Page{Offset: 0, Count: 5},
+			want:    []string{"1", "2", "fizz", "4", "buzz"},
+		},
+		"OK - middle page": {
+			ruleSet: RuleSet{Rules: fizzbuzzRules, Limit: 16},
+			page:    Page{Offset: 10, Count: 5},
+			want:    []string{"11", "fizz", "13", "14", "fizzbuzz"},
+		},
+		"OK - last page truncated at the limit": {
+			ruleSet: RuleSet{Rules: fizzbuzzRules, Limit: 16},
+			page:    Page{Offset: 14, Count: 5},
+			want:    []string{"fizzbuzz", "16"},
+		},
+		"OK - offset after the limit": {
+			ruleSet: RuleSet{Rules: fizzbuzzRules, Limit: 16},
+			page:    Page{Offset: 20, Count: 5},
+			want:    []string{},
+		},
+		"OK - far page": {
+			ruleSet: RuleSet{Rules: fizzbuzzRules, Limit: 2000000000},
+			page:    Page{Offset: 1000000000, Count: 3},
+			want:    []string{"1000000001", "fizz", "1000000003"},
+		},
+		"OK - huge count": {
+			ruleSet: RuleSet{Rules: fizzbuzzRules, Limit: 16},
+			page:    Page{Offset: 13, Count: int(^uint(0) >> 1)},
+			want:    []string{"14", "fizzbuzz", "16"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			got, gotErr := ExecPage(context.Background(), tt.ruleSet, tt.page)
+			if tt.wantErr {
+				assertions.Error(gotErr)
+			} else {
+				assertions.NoError(gotErr)
+			}
+			assertions.Equal(tt.want, got)
+		})
+	}
+}
+
+func Test_Page_Next(t *testing.T) {
+	tests := map[string]struct {
+		page     Page
+		limit    int
+		wantNext Page
+		wantOk   bool
+	}{
+		"first page":               {page: Page{Offset: 0, Count: 5}, limit: 16, wantNext: Page{Offset: 5, Count: 5}, wantOk: true},
+		"page before the last":     {page: Page{Offset: 10, Count: 5}, limit: 16, wantNext: Page{Offset: 15, Count: 5}, wantOk: true},
+		"page ending on the limit": {page: Page{Offset: 11, Count: 5}, limit: 16, wantOk: false},
+		"last page":                {page: Page{Offset: 15, Count: 5}, limit: 16, wantOk: false},
+		"page after the limit":     {page: Page{Offset: 20, Count: 5}, limit: 16, wantOk: false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			gotNext, gotOk := tt.page.Next(tt.limit)
+			assert.Equal(t, tt.wantOk, gotOk)
+			assert.Equal(t, tt.wantNext, gotNext)
+		})
+	}
+}
+
+func Test_Page_Size(t *testing.T) {
+	tests := map[string]struct {
+		page     Page
+		limit    int
+		wantSize int
+	}{
+		"first page":           {page: Page{Offset: 0, Count: 5}, limit: 16, wantSize: 5},
+		"last page":            {page: Page{Offset: 15, Count: 5}, limit: 16, wantSize: 1},
+		"page after the limit": {page: Page{Offset: 20, Count: 5}, limit: 16, wantSize: 0},
+		"page of a huge limit": {page: Page{Offset: 100, Count: math.MaxInt}, limit: math.MaxInt, wantSize: math.MaxInt - 100},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.wantSize, tt.page.Size(tt.limit))
+		})
+	}
+}
+
+func Test_ExecFizzbuzz_contextDone(t *testing.T) {
+	params := Params{Int1: 3, Int2: 5, Limit: 1000000000, Str1: "fizz", Str2: "buzz"}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelExpired()
+
+	tests := map[string]struct {
+		ctx     context.Context
+		wantErr error
+	}{
+		"KO - canceled": {
+			ctx:     canceled,
+			wantErr: context.Canceled,
+		},
+		"KO - deadline exceeded during the process": {
+			ctx:     expired,
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			got, gotErr := ExecFizzbuzz(tt.ctx, params)
+			assertions.ErrorIs(gotErr, tt.wantErr)
+			assertions.Empty(got)
+		})
+	}
+}
+
+func Test_Generator_contextDone(t *testing.T) {
+	assertions := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gen, errGen := NewRulesGenerator(ctx, RuleSet{Rules: []Rule{{Divisor: 3, Word: "fizz"}}, Limit: 10 * ctxCheckInterval})
+	assertions.NoError(errGen)
+
+	generated := 0
+	for _, ok := gen.Next(); ok; _, ok = gen.Next() {
+		generated++
+		if generated == ctxCheckInterval/2 {
+			cancel()
+		}
+	}
+	// the context is only checked periodically
+	assertions.Equal(ctxCheckInterval, generated)
+	assertions.ErrorIs(gen.Err(), context.Canceled)
+
+	// the generator stays stopped
+	_, ok := gen.Next()
+	assertions.False(ok)
+}
@@ -0,0 +1,116 @@
+package lrucache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache keeps values up to a total size in bytes, evicting the least recently used ones to make room
+// the size of an entry is the length of its key and its value, the values must not be modified once added
+// It is safe for concurrent use
+type Cache struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	bytes   int64
+	order   *list.List
+	entries map[string]*list.Element
+	stats   Stats
+}
+
+// entry is the element of the recency list, the most recently used first
+type entry struct {
+	key   string
+	value []byte
+}
+
+// Stats are the statistics of the use of the cache since its creation
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// Bytes is the total size of the entries
+	Bytes   int64
+	Entries int
+}
+
+// New creates a cache keeping values up to this total size in bytes
+func New(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get gives the value of the key, ok is false if it is not cached
+func (c *Cache) Get(key string) (value []byte, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*entry).value, true
+}
+
+// Peek gives the value of the key like Get, but without counting it in the statistics nor marking it used
+func (c *Cache) Peek(key string) (value []byte, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	return elem.Value.(*entry).value, true
+}
+
+// Add caches the value of the key, replacing its previous value, and evicts the least recently used entries
+// if the cache is full
+// a value larger than the cache is not kept
+func (c *Cache) Add(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	size := entrySize(key, value)
+	if size > c.maxBytes {
+		return
+	}
+	for c.bytes+size > c.maxBytes {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+	c.entries[key] = c.order.PushFront(&entry{key: key, value: value})
+	c.bytes += size
+}
+
+// Stats gives the statistics of the use of the cache
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Bytes = c.bytes
+	stats.Entries = len(c.entries)
+	return stats
+}
+
+// remove removes the entry of the element
+// the caller must hold the lock
+func (c *Cache) remove(elem *list.Element) {
+	e := c.order.Remove(elem).(*entry)
+	delete(c.entries, e.key)
+	c.bytes -= entrySize(e.key, e.value)
+}
+
+func entrySize(key string, value []byte) int64 {
+	return int64(len(key) + len(value))
+}
@@ -0,0 +1,94 @@
+package lrucache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Cache(t *testing.T) {
+	assertions := assert.New(t)
+
+	// room for three entries of 10 bytes
+	c := New(30)
+	c.Add("fizz", []byte("123456"))
+	c.Add("buzz", []byte("123456"))
+	c.Add("bazz", []byte("123456"))
+
+	got, ok := c.Get("fizz")
+	assertions.True(ok)
+	assertions.Equal([]byte("123456"), got)
+	_, ok = c.Get("fizzbuzz")
+	assertions.False(ok)
+
+	// the least recently used entry is evicted, fizz having been used after buzz
+	c.Add("fazz", []byte("123456"))
+	_, ok = c.Get("buzz")
+	assertions.False(ok)
+	for _, key := range []string{"fizz", "bazz", "fazz"} {
+		_, ok = c.Get(key)
+		assertions.True(ok, key)
+	}
+	assertions.Equal(Stats{Hits: 4, Misses: 2, Evictions: 1, Bytes: 30, Entries: 3}, c.Stats())
+
+	// a larger value evicts as many entries as needed
+	c.Add("bozz", []byte("1234567890123456"))
+	assertions.Equal(Stats{Hits: 4, Misses: 2, Evictions: 3, Bytes: 30, Entries: 2}, c.Stats())
+
+	// a value replaced is not evicted
+	c.Add("bozz", []byte("12"))
+	got, _ = c.Get("bozz")
+	assertions.Equal([]byte("12"), got)
+	assertions.Equal(Stats{Hits: 5, Misses: 2, Evictions: 3, Bytes: 16, Entries: 2}, c.Stats())
+
+	// a value larger than the cache is not kept, nor its previous value
+	c.Add("bozz", make([]byte, 100))
+	_, ok = c.Get("bozz")
+	assertions.False(ok)
+	assertions.Equal(Stats{Hits: 5, Misses: 3, Evictions: 3, Bytes: 10, Entries: 1}, c.Stats())
+}
+
+func Test_Cache_Peek(t *testing.T) {
+	assertions := assert.New(t)
+
+	c := New(20)
+	c.Add("fizz", []byte("123456"))
+	c.Add("buzz", []byte("123456"))
+
+	got, ok := c.Peek("fizz")
+	assertions.True(ok)
+	assertions.Equal([]byte("123456"), got)
+	_, ok = c.Peek("bazz")
+	assertions.False(ok)
+	assertions.Equal(Stats{Bytes: 20, Entries: 2}, c.Stats())
+
+	// the entry peeked is still the least recently used
+	c.Add("bazz", []byte("123456"))
+	_, ok = c.Peek("fizz")
+	assertions.False(ok)
+}
+
+func Test_Cache_concurrent(t *testing.T) {
+	c := New(1000)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := strconv.Itoa((i * j) % 200)
+				if _, ok := c.Get(key); !ok {
+					c.Add(key, []byte(key))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(10000), stats.Hits+stats.Misses)
+	assert.LessOrEqual(t, stats.Bytes, int64(1000))
+}
@@ -0,0 +1,56 @@
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Header is the HTTP header carrying the request ID, from the gateway and back to the client
+const Header = "X-Request-ID"
+
+// maxLength is the greatest length of a request ID accepted from a client
+const maxLength = 128
+
+// key is the context key of the request ID
+type key struct{}
+
+// New generates a request ID
+func New() string {
+	return uuid.New().String()
+}
+
+// Valid tells if a request ID received from a client can be used, so it can't be abused to forge logs
+// it must be made of at most 128 printable ASCII characters, without spaces
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext gives a copy of the context carrying this request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// FromContext gives the request ID carried by the context, empty if there is none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
+
+// Logger gives a logger adding the request ID carried by the context, if any, to each log
+func Logger(ctx context.Context) zerolog.Logger {
+	if id := FromContext(ctx); id != "" {
+		return log.With().Str("requestID", id).Logger()
+	}
+	return log.Logger
+}
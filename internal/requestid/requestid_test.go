@@ -0,0 +1,64 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Valid(t *testing.T) {
+	tests := map[string]struct {
+		id   string
+		want bool
+	}{
+		"OK - uuid": {
+			id:   "3f2b8e4c-7d1a-4c5e-9b0f-2a6d8c4e1f3b",
+			want: true,
+		},
+		"OK - gateway format": {
+			id:   "Root=1-67891233-abcdef012345678912345678;Sampled=1",
+			want: true,
+		},
+		"OK - max length": {
+			id:   strings.Repeat("a", 128),
+			want: true,
+		},
+		"KO - empty": {
+			id:   "",
+			want: false,
+		},
+		"KO - too long": {
+			id:   strings.Repeat("a", 129),
+			want: false,
+		},
+		"KO - space": {
+			id:   "fizz buzz",
+			want: false,
+		},
+		"KO - line break": {
+			id:   "fizz\nbuzz",
+			want: false,
+		},
+		"KO - not ascii": {
+			id:   "fizzbüzz",
+			want: false,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Valid(tt.id))
+		})
+	}
+}
+
+func Test_Context(t *testing.T) {
+	assertions := assert.New(t)
+
+	assertions.Empty(FromContext(context.Background()))
+	id := New()
+	assertions.True(Valid(id))
+	assertions.Equal(id, FromContext(NewContext(context.Background(), id)))
+	assertions.NotEqual(id, New(), "each request ID is unique")
+}
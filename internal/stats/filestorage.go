@@ -0,0 +1,230 @@
+package stats
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"fizzbuzz-server/internal/fizzbuzz"
+)
+
+const (
+	snapshotFileName = "snapshot.jsonl"
+	logFileName      = "log.jsonl"
+)
+
+// Storage persists the counts of a FizzbuzzCounter
+type Storage interface {
+	// Load retrieves all the persisted counts
+	Load() (Counts, error)
+	// Append records one more request for these parameters
+	Append(params fizzbuzz.Params) error
+	// AppendRules records one more request for this rule set
+	AppendRules(ruleSet fizzbuzz.RuleSet) error
+	// Flush persists the complete counts, replacing everything appended before
+	Flush(counts Counts) error
+	// Ping checks the storage is usable
+	Ping() error
+	// Close releases the resources used by the storage
+	Close() error
+}
+
+// FileStorage is a Storage keeping the counts in a directory, using JSON lines files:
+// a snapshot of the counts and an append-only log of the requests received since the snapshot
+type FileStorage struct {
+	dir     string
+	logFile *os.File
+}
+
+// snapshotLine is a line of the snapshot, counting either parameters or a rule set
+type snapshotLine struct {
+	Count  int              `json:"count"`
+	Params *fizzbuzz.Params `json:"params,omitempty"`
+	Rules  json.RawMessage  `json:"rules,omitempty"`
+}
+
+// logLine is a line of the log, either the parameters or the rule set of a request
+// the parameters fields are promoted so the line of parameters is their JSON encoding,
+// while the line of a rule set only has its 'rules' and 'limit' fields
+type logLine struct {
+	fizzbuzz.Params
+	Rules []fizzbuzz.Rule `json:"rules,omitempty"`
+}
+
+// NewFileStorage creates a FileStorage in this directory, creating it if needed
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if errMkdir := os.MkdirAll(dir, 0o755); errMkdir != nil {
+		return nil, fmt.Errorf("creating directory: %w", errMkdir)
+	}
+	logFile, errOpen := os.OpenFile(filepath.Join(dir, logFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if errOpen != nil {
+		return nil, fmt.Errorf("opening log file: %w", errOpen)
+	}
+	return &FileStorage{dir: dir, logFile: logFile}, nil
+}
+
+// Load reads the snapshot then replays the log on top of it
+func (fs *FileStorage) Load() (Counts, error) {
+	counts := Counts{Params: make(map[fizzbuzz.Params]int), Rules: make(map[string]int)}
+
+	errSnapshot := readLines(filepath.Join(fs.dir, snapshotFileName), func(line []byte) error {
+		var snapLine snapshotLine
+		if errJson := json.Unmarshal(line, &snapLine); errJson != nil {
+			return errJson
+		}
+		switch {
+		case snapLine.Params != nil:
+			counts.Params[*snapLine.Params] += snapLine.Count
+		case snapLine.Rules != nil:
+			var ruleSet fizzbuzz.RuleSet
+			if errJson := json.Unmarshal(snapLine.Rules, &ruleSet); errJson != nil {
+				return errJson
+			}
+			counts.Rules[ruleSet.Key()] += snapLine.Count
+		default:
+			return errors.New("neither params nor rules")
+		}
+		return nil
+	})
+	if errSnapshot != nil {
+		return Counts{}, fmt.Errorf("reading snapshot: %w", errSnapshot)
+	}
+
+	errLog := readLines(filepath.Join(fs.dir, logFileName), func(line []byte) error {
+		var lLine logLine
+		if errJson := json.Unmarshal(line, &lLine); errJson != nil {
+			return errJson
+		}
+		if lLine.Rules != nil {
+			counts.Rules[fizzbuzz.RuleSet{Rules: lLine.Rules, Limit: lLine.Limit}.Key()]++
+		} else {
+			counts.Params[lLine.Params]++
+		}
+		return nil
+	})
+	if errLog != nil {
+		return Counts{}, fmt.Errorf("reading log: %w", errLog)
+	}
+
+	return counts, nil
+}
+
+// Append writes the parameters at the end of the log
+func (fs *FileStorage) Append(params fizzbuzz.Params) error {
+	return fs.appendLine(params)
+}
+
+// AppendRules writes the rule set at the end of the log
+func (fs *FileStorage) AppendRules(ruleSet fizzbuzz.RuleSet) error {
+	return fs.appendLine(ruleSet)
+}
+
+// appendLine writes the JSON encoded value as a new line of the log
+func (fs *FileStorage) appendLine(v any) error {
+	line, errJson := json.Marshal(v)
+	if errJson != nil {
+		return fmt.Errorf("marshalling json: %w", errJson)
+	}
+	if _, errWrite := fs.logFile.Write(append(line, '\n')); errWrite != nil {
+		return fmt.Errorf("writing log: %w", errWrite)
+	}
+	return nil
+}
+
+// Flush atomically replaces the snapshot with these counts then empties the log
+func (fs *FileStorage) Flush(counts Counts) error {
+	tmpFile, errCreate := os.CreateTemp(fs.dir, snapshotFileName+".*.tmp")
+	if errCreate != nil {
+		return fmt.Errorf("creating temporary snapshot: %w", errCreate)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	writer := bufio.NewWriter(tmpFile)
+	encoder := json.NewEncoder(writer)
+	for params, count := range counts.Params {
+		params := params
+		if errEncode := encoder.Encode(snapshotLine{Count: count, Params: &params}); errEncode != nil {
+			tmpFile.Close()
+			return fmt.Errorf("writing snapshot: %w", errEncode)
+		}
+	}
+	for key, count := range counts.Rules {
+		if errEncode := encoder.Encode(snapshotLine{Count: count, Rules: json.RawMessage(key)}); errEncode != nil {
+			tmpFile.Close()
+			return fmt.Errorf("writing snapshot: %w", errEncode)
+		}
+	}
+	if errFlush := writer.Flush(); errFlush != nil {
+		tmpFile.Close()
+		return fmt.Errorf("writing snapshot: %w", errFlush)
+	}
+	if errSync := tmpFile.Sync(); errSync != nil {
+		tmpFile.Close()
+		return fmt.Errorf("syncing snapshot: %w", errSync)
+	}
+	if errClose := tmpFile.Close(); errClose != nil {
+		return fmt.Errorf("closing snapshot: %w", errClose)
+	}
+	if errRename := os.Rename(tmpFile.Name(), filepath.Join(fs.dir, snapshotFileName)); errRename != nil {
+		return fmt.Errorf("replacing snapshot: %w", errRename)
+	}
+
+	// the log is only emptied once the snapshot containing its requests is in place
+	if errTruncate := fs.logFile.Truncate(0); errTruncate != nil {
+		return fmt.Errorf("truncating log: %w", errTruncate)
+	}
+	return nil
+}
+
+// Ping checks the directory of the storage still exists
+func (fs *FileStorage) Ping() error {
+	info, errStat := os.Stat(fs.dir)
+	if errStat != nil {
+		return fmt.Errorf("checking directory: %w", errStat)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", fs.dir)
+	}
+	return nil
+}
+
+// Close closes the log file
+func (fs *FileStorage) Close() error {
+	return fs.logFile.Close()
+}
+
+// readLines calls parse on each non-empty line of the file, a missing file has no lines
+// an invalid last line is ignored as it can be the result of an interrupted write
+func readLines(path string, parse func(line []byte) error) error {
+	file, errOpen := os.Open(path)
+	if errors.Is(errOpen, os.ErrNotExist) {
+		return nil
+	}
+	if errOpen != nil {
+		return errOpen
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	lineNb := 0
+	for {
+		line, errRead := reader.ReadBytes('\n')
+		if errRead != nil && !errors.Is(errRead, io.EOF) {
+			return errRead
+		}
+		lineNb++
+		complete := len(line) > 0 && line[len(line)-1] == '\n'
+		if len(line) > 1 || (len(line) == 1 && !complete) {
+			if errParse := parse(line); errParse != nil && complete {
+				return fmt.Errorf("line %d: %w", lineNb, errParse)
+			}
+		}
+		if errRead != nil {
+			return nil
+		}
+	}
+}
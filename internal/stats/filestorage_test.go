@@ -0,0 +1,129 @@
+package stats
+
+import (
+	"fizzbuzz-server/internal/fizzbuzz"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FileStorage_Load(t *testing.T) {
+	params1 := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params2 := fizzbuzz.Params{Int1: 2, Int2: 7, Limit: 16, Str1: "fazz", Str2: "bozz"}
+	ruleSet := fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}, {Divisor: 7, Word: "bazz"}}, Limit: 16}
+
+	tests := map[string]struct {
+		snapshot string
+		log      string
+		want     Counts
+		wantErr  string
+	}{
+		"no files": {
+			want: Counts{Params: map[fizzbuzz.Params]int{}, Rules: map[string]int{}},
+		},
+		"snapshot and log": {
+			snapshot: `{"count":2,"params":{"int1":3,"int2":5,"limit":16,"str1":"fizz","str2":"buzz"}}` + "\n" +
+				`{"count":4,"rules":{"rules":[{"divisor":3,"word":"fizz"},{"divisor":7,"word":"bazz"}],"limit":16}}` + "\n",
+			log: `{"int1":3,"int2":5,"limit":16,"str1":"fizz","str2":"buzz"}` + "\n" +
+				`{"rules":[{"divisor":3,"word":"fizz"},{"divisor":7,"word":"bazz"}],"limit":16}` + "\n" +
+				`{"int1":2,"int2":7,"limit":16,"str1":"fazz","str2":"bozz"}` + "\n",
+			want: Counts{
+				Params: map[fizzbuzz.Params]int{params1: 3, params2: 1},
+				Rules:  map[string]int{ruleSet.Key(): 5},
+			},
+		},
+		"interrupted write at the end of the log": {
+			log: `{"int1":3,"int2":5,"limit":16,"str1":"fizz","str2":"buzz"}` + "\n" +
+				`{"int1":2,"int2":7,"li`,
+			want: Counts{Params: map[fizzbuzz.Params]int{params1: 1}, Rules: map[string]int{}},
+		},
+		"corrupted snapshot": {
+			snapshot: `aaa` + "\n" +
+				`{"count":2,"params":{"int1":3,"int2":5,"limit":16,"str1":"fizz","str2":"buzz"}}` + "\n",
+			wantErr: "reading snapshot: line 1",
+		},
+		"snapshot line without params nor rules": {
+			snapshot: `{"count":2}` + "\n",
+			wantErr:  "reading snapshot: line 1: neither params nor rules",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+			dir := t.TempDir()
+			if tt.snapshot != "" {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, snapshotFileName), []byte(tt.snapshot), 0o644))
+			}
+			if tt.log != "" {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, logFileName), []byte(tt.log), 0o644))
+			}
+			fs, errNew := NewFileStorage(dir)
+			require.NoError(t, errNew)
+			defer fs.Close()
+
+			got, gotErr := fs.Load()
+			if tt.wantErr != "" {
+				assertions.ErrorContains(gotErr, tt.wantErr)
+			} else {
+				assertions.NoError(gotErr)
+				assertions.Equal(tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_FileStorage_AppendFlush(t *testing.T) {
+	assertions := assert.New(t)
+
+	params1 := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params2 := fizzbuzz.Params{Int1: 2, Int2: 7, Limit: 16, Str1: "fazz", Str2: "bozz"}
+	ruleSet := fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}, {Divisor: 7, Word: "bazz"}}, Limit: 16}
+	dir := t.TempDir()
+
+	fs, errNew := NewFileStorage(dir)
+	require.NoError(t, errNew)
+	assertions.NoError(fs.Append(params1))
+	assertions.NoError(fs.Append(params1))
+	assertions.NoError(fs.AppendRules(ruleSet))
+	got, errLoad := fs.Load()
+	assertions.NoError(errLoad)
+	want := Counts{Params: map[fizzbuzz.Params]int{params1: 2}, Rules: map[string]int{ruleSet.Key(): 1}}
+	assertions.Equal(want, got, "wrong counts after append")
+
+	// flush replaces the log with the snapshot
+	assertions.NoError(fs.Flush(want))
+	logContent, errRead := os.ReadFile(filepath.Join(dir, logFileName))
+	assertions.NoError(errRead)
+	assertions.Empty(logContent, "log not emptied")
+	assertions.NoError(fs.Append(params2))
+	assertions.NoError(fs.Close())
+
+	// counts are restored by another storage on the same directory
+	fs, errNew = NewFileStorage(dir)
+	require.NoError(t, errNew)
+	defer fs.Close()
+	got, errLoad = fs.Load()
+	assertions.NoError(errLoad)
+	assertions.Equal(
+		Counts{Params: map[fizzbuzz.Params]int{params1: 2, params2: 1}, Rules: map[string]int{ruleSet.Key(): 1}},
+		got,
+		"wrong counts after reopening",
+	)
+}
+
+func Test_FileStorage_Ping(t *testing.T) {
+	assertions := assert.New(t)
+
+	dir := filepath.Join(t.TempDir(), "stats")
+	fs, errNew := NewFileStorage(dir)
+	assertions.NoError(errNew)
+	defer fs.Close()
+	assertions.NoError(fs.Ping())
+
+	// the directory removed after the start makes the storage unusable
+	assertions.NoError(os.RemoveAll(dir))
+	assertions.Error(fs.Ping())
+}
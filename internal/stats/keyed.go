@@ -0,0 +1,134 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"fizzbuzz-server/internal/fizzbuzz"
+)
+
+// Caller is the API key a request is made with
+type Caller struct {
+	// Name identifies the key, the counts of the key are kept under it
+	Name string
+	// Admin callers see the global counts
+	Admin bool
+}
+
+// callerKey is the context key of the caller
+type callerKey struct{}
+
+// NewCallerContext gives a copy of the context carrying the caller of the request
+func NewCallerContext(ctx context.Context, caller Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFromContext gives the caller of the request, ok is false if the request is anonymous
+func CallerFromContext(ctx context.Context) (caller Caller, ok bool) {
+	caller, ok = ctx.Value(callerKey{}).(Caller)
+	return caller, ok
+}
+
+// KeyedCounter is a Counter also keeping apart the counts of each API key
+// the requests are counted globally and for the key of their caller, if any
+// the counts retrieved are the ones of the key of the caller, or the global ones for the admins
+// and the anonymous requests
+// It is safe for concurrent use
+type KeyedCounter struct {
+	// Counter keeps the global counts, it is the one used for the cardinality and the ping
+	Counter
+	newCounter func(name string) Counter
+
+	mu   sync.Mutex
+	keys map[string]Counter
+}
+
+var _ Counter = (*KeyedCounter)(nil)
+
+// NewKeyedCounter creates a counter keeping the global counts in this counter,
+// and the counts of each key in a counter created by newCounter the first time the key is used
+func NewKeyedCounter(global Counter, newCounter func(name string) Counter) *KeyedCounter {
+	return &KeyedCounter{Counter: global, newCounter: newCounter, keys: make(map[string]Counter)}
+}
+
+// Inc increments the global counter and the one of the caller for these parameters
+func (kc *KeyedCounter) Inc(ctx context.Context, params fizzbuzz.Params) error {
+	if errInc := kc.Counter.Inc(ctx, params); errInc != nil {
+		return errInc
+	}
+	if caller, ok := CallerFromContext(ctx); ok {
+		if errInc := kc.keyCounter(caller.Name).Inc(ctx, params); errInc != nil {
+			return fmt.Errorf("counting for key %s: %w", caller.Name, errInc)
+		}
+	}
+	return nil
+}
+
+// IncRules increments the global counter and the one of the caller for this rule set
+func (kc *KeyedCounter) IncRules(ctx context.Context, ruleSet fizzbuzz.RuleSet) error {
+	if errInc := kc.Counter.IncRules(ctx, ruleSet); errInc != nil {
+		return errInc
+	}
+	if caller, ok := CallerFromContext(ctx); ok {
+		if errInc := kc.keyCounter(caller.Name).IncRules(ctx, ruleSet); errInc != nil {
+			return fmt.Errorf("counting for key %s: %w", caller.Name, errInc)
+		}
+	}
+	return nil
+}
+
+// Get retrieves the numbers of request received for these parameters, as seen by the caller
+func (kc *KeyedCounter) Get(ctx context.Context, params fizzbuzz.Params) (int, error) {
+	return kc.view(ctx).Get(ctx, params)
+}
+
+// MostFrequentReq retrieves the most frequent request, as seen by the caller
+func (kc *KeyedCounter) MostFrequentReq(ctx context.Context) (MostFrequentReq, error) {
+	return kc.view(ctx).MostFrequentReq(ctx)
+}
+
+// TopK retrieves the k most frequent requests, as seen by the caller
+func (kc *KeyedCounter) TopK(ctx context.Context, k int) ([]ReqCount, error) {
+	return kc.view(ctx).TopK(ctx, k)
+}
+
+// MostFrequentRules retrieves the most frequent generalized request, as seen by the caller
+func (kc *KeyedCounter) MostFrequentRules(ctx context.Context) (MostFrequentRules, error) {
+	return kc.view(ctx).MostFrequentRules(ctx)
+}
+
+// Flush persists the global counts then the counts of each key
+func (kc *KeyedCounter) Flush() error {
+	if errFlush := kc.Counter.Flush(); errFlush != nil {
+		return errFlush
+	}
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	for name, counter := range kc.keys {
+		if errFlush := counter.Flush(); errFlush != nil {
+			return fmt.Errorf("flushing key %s: %w", name, errFlush)
+		}
+	}
+	return nil
+}
+
+// view gives the counter whose counts the caller sees
+func (kc *KeyedCounter) view(ctx context.Context) Counter {
+	if caller, ok := CallerFromContext(ctx); ok && !caller.Admin {
+		return kc.keyCounter(caller.Name)
+	}
+	return kc.Counter
+}
+
+// keyCounter gives the counter of this key, creating it if needed
+func (kc *KeyedCounter) keyCounter(name string) Counter {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	counter, ok := kc.keys[name]
+	if !ok {
+		counter = kc.newCounter(name)
+		kc.keys[name] = counter
+	}
+	return counter
+}
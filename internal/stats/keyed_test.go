@@ -0,0 +1,81 @@
+package stats
+
+import (
+	"context"
+	"fizzbuzz-server/internal/fizzbuzz"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_KeyedCounter(t *testing.T) {
+	params1 := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params2 := fizzbuzz.Params{Int1: 2, Int2: 7, Limit: 16, Str1: "fazz", Str2: "bozz"}
+	ruleSet := fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}}, Limit: 16}
+	client1 := NewCallerContext(context.Background(), Caller{Name: "client1"})
+	client2 := NewCallerContext(context.Background(), Caller{Name: "client2"})
+	admin := NewCallerContext(context.Background(), Caller{Name: "admin", Admin: true})
+
+	newCounters := map[string]func(t *testing.T) *KeyedCounter{
+		"memory": func(t *testing.T) *KeyedCounter {
+			return NewKeyedCounter(NewFizzbuzzCounter(), func(string) Counter { return NewFizzbuzzCounter() })
+		},
+		"redis": func(t *testing.T) *KeyedCounter {
+			server := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+			t.Cleanup(func() { client.Close() })
+			return NewKeyedCounter(NewRedisCounter(client, "test"), func(name string) Counter {
+				return NewRedisCounter(client, "test:keys:"+name)
+			})
+		},
+	}
+	for name, newCounter := range newCounters {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			kc := newCounter(t)
+			require.NoError(t, kc.Inc(client1, params1))
+			require.NoError(t, kc.Inc(client1, params1))
+			require.NoError(t, kc.Inc(client2, params2))
+			require.NoError(t, kc.Inc(context.Background(), params2))
+			require.NoError(t, kc.Inc(admin, params2))
+			require.NoError(t, kc.IncRules(client2, ruleSet))
+
+			// each key sees its own counts
+			gotReq, gotErr := kc.MostFrequentReq(client1)
+			assertions.NoError(gotErr)
+			assertions.Equal(MostFrequentReq{Count: 2, Params: []fizzbuzz.Params{params1}}, gotReq)
+			gotReq, gotErr = kc.MostFrequentReq(client2)
+			assertions.NoError(gotErr)
+			assertions.Equal(MostFrequentReq{Count: 1, Params: []fizzbuzz.Params{params2}}, gotReq)
+			gotCount, gotErr := kc.Get(client2, params1)
+			assertions.NoError(gotErr)
+			assertions.Equal(0, gotCount)
+			gotTop, gotErr := kc.TopK(client1, 10)
+			assertions.NoError(gotErr)
+			assertions.Equal([]ReqCount{{Count: 2, Params: params1}}, gotTop)
+			gotRules, gotErr := kc.MostFrequentRules(client1)
+			assertions.NoError(gotErr)
+			assertions.Equal(0, gotRules.Count)
+			gotRules, gotErr = kc.MostFrequentRules(client2)
+			assertions.NoError(gotErr)
+			assertions.Equal(MostFrequentRules{Count: 1, RuleSets: []fizzbuzz.RuleSet{ruleSet}}, gotRules)
+
+			// the admins and the anonymous requests see the global counts
+			for _, ctx := range []context.Context{admin, context.Background()} {
+				gotReq, gotErr = kc.MostFrequentReq(ctx)
+				assertions.NoError(gotErr)
+				assertions.Equal(MostFrequentReq{Count: 3, Params: []fizzbuzz.Params{params2}}, gotReq)
+			}
+			gotCard, gotErr := kc.Cardinality(client1)
+			assertions.NoError(gotErr)
+			assertions.Equal(Cardinality{Params: 2, Rules: 1}, gotCard)
+
+			assertions.NoError(kc.Ping(client1))
+			assertions.NoError(kc.Flush())
+		})
+	}
+}
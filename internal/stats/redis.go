@@ -0,0 +1,237 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"fizzbuzz-server/internal/fizzbuzz"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisCounter is an implementation of Counter keeping the counts in Redis sorted sets
+// The members of the sets are the JSON encoded parameters or rule sets and the scores their counts,
+// so several servers using the same Redis share their counts
+type RedisCounter struct {
+	client   *redis.Client
+	key      string
+	rulesKey string
+}
+
+var _ Counter = (*RedisCounter)(nil)
+
+// NewRedisCounter creates a counter using this client
+// the sorted sets are stored under 'prefix:counts' for the parameters and 'prefix:rules' for the rule sets
+func NewRedisCounter(client *redis.Client, prefix string) *RedisCounter {
+	return &RedisCounter{client: client, key: prefix + ":counts", rulesKey: prefix + ":rules"}
+}
+
+// Inc increments the score of these parameters
+func (rc *RedisCounter) Inc(ctx context.Context, params fizzbuzz.Params) error {
+	member, errMember := encodeMember(params)
+	if errMember != nil {
+		return errMember
+	}
+	if errIncr := rc.client.ZIncrBy(ctx, rc.key, 1, member).Err(); errIncr != nil {
+		return fmt.Errorf("incrementing score: %w", errIncr)
+	}
+	return nil
+}
+
+// Get retrieves the score of these parameters
+func (rc *RedisCounter) Get(ctx context.Context, params fizzbuzz.Params) (int, error) {
+	member, errMember := encodeMember(params)
+	if errMember != nil {
+		return 0, errMember
+	}
+	score, errScore := rc.client.ZScore(ctx, rc.key, member).Result()
+	if errors.Is(errScore, redis.Nil) {
+		return 0, nil
+	}
+	if errScore != nil {
+		return 0, fmt.Errorf("retrieving score: %w", errScore)
+	}
+	return int(score), nil
+}
+
+// MostFrequentReq retrieves the highest score then all the members having it
+func (rc *RedisCounter) MostFrequentReq(ctx context.Context) (MostFrequentReq, error) {
+	top, errTop := rc.client.ZRevRangeWithScores(ctx, rc.key, 0, 0).Result()
+	if errTop != nil {
+		return MostFrequentReq{}, fmt.Errorf("retrieving highest score: %w", errTop)
+	}
+	if len(top) == 0 {
+		return MostFrequentReq{Count: 0, Params: []fizzbuzz.Params{}}, nil
+	}
+
+	maxMembers, errMax := rc.withScore(ctx, rc.key, top[0].Score, 0)
+	if errMax != nil {
+		return MostFrequentReq{}, errMax
+	}
+	maxParams := make([]fizzbuzz.Params, 0, len(maxMembers))
+	for _, z := range maxMembers {
+		reqCount, errDecode := decodeMember(z)
+		if errDecode != nil {
+			return MostFrequentReq{}, errDecode
+		}
+		maxParams = append(maxParams, reqCount.Params)
+	}
+	sort.Slice(maxParams, func(i, j int) bool { return paramsLess(maxParams[i], maxParams[j]) })
+	return MostFrequentReq{Count: int(top[0].Score), Params: maxParams}, nil
+}
+
+// maxExtraTies is the number of members having the lowest score retrieved by TopK fetched beyond those needed,
+// so a score shared by many members does not pull the whole sorted set
+const maxExtraTies = 1000
+
+// TopK retrieves the k members with the highest scores
+// Redis sorts members with the same score lexicographically, so the members having the lowest retrieved score
+// are fetched to apply the same tie-breaking as FizzbuzzCounter
+// beyond maxExtraTies more of them than needed, the ones left out are those last in the Redis order
+func (rc *RedisCounter) TopK(ctx context.Context, k int) ([]ReqCount, error) {
+	if k <= 0 {
+		return []ReqCount{}, nil
+	}
+
+	top, errTop := rc.client.ZRevRangeWithScores(ctx, rc.key, 0, int64(k-1)).Result()
+	if errTop != nil {
+		return nil, fmt.Errorf("retrieving highest scores: %w", errTop)
+	}
+	if len(top) == 0 {
+		return []ReqCount{}, nil
+	}
+
+	lowestScore := top[len(top)-1].Score
+	reqCounts := make([]ReqCount, 0, len(top))
+	for _, z := range top {
+		if z.Score == lowestScore {
+			break
+		}
+		reqCount, errDecode := decodeMember(z)
+		if errDecode != nil {
+			return nil, errDecode
+		}
+		reqCounts = append(reqCounts, reqCount)
+	}
+	lowestMembers, errLowest := rc.withScore(ctx, rc.key, lowestScore, int64(k-len(reqCounts)+maxExtraTies))
+	if errLowest != nil {
+		return nil, errLowest
+	}
+	for _, z := range lowestMembers {
+		reqCount, errDecode := decodeMember(z)
+		if errDecode != nil {
+			return nil, errDecode
+		}
+		reqCounts = append(reqCounts, reqCount)
+	}
+
+	sort.Slice(reqCounts, func(i, j int) bool { return reqCountLess(reqCounts[j], reqCounts[i]) })
+	if len(reqCounts) > k {
+		reqCounts = reqCounts[:k]
+	}
+	return reqCounts, nil
+}
+
+// IncRules increments the score of this rule set
+func (rc *RedisCounter) IncRules(ctx context.Context, ruleSet fizzbuzz.RuleSet) error {
+	if errIncr := rc.client.ZIncrBy(ctx, rc.rulesKey, 1, ruleSet.Key()).Err(); errIncr != nil {
+		return fmt.Errorf("incrementing score: %w", errIncr)
+	}
+	return nil
+}
+
+// MostFrequentRules retrieves the highest score of the rule sets then all the rule sets having it
+func (rc *RedisCounter) MostFrequentRules(ctx context.Context) (MostFrequentRules, error) {
+	top, errTop := rc.client.ZRevRangeWithScores(ctx, rc.rulesKey, 0, 0).Result()
+	if errTop != nil {
+		return MostFrequentRules{}, fmt.Errorf("retrieving highest score: %w", errTop)
+	}
+	if len(top) == 0 {
+		return MostFrequentRules{Count: 0, RuleSets: []fizzbuzz.RuleSet{}}, nil
+	}
+
+	maxMembers, errMax := rc.withScore(ctx, rc.rulesKey, top[0].Score, 0)
+	if errMax != nil {
+		return MostFrequentRules{}, errMax
+	}
+	maxKeys := make([]string, 0, len(maxMembers))
+	for _, z := range maxMembers {
+		key, ok := z.Member.(string)
+		if !ok {
+			return MostFrequentRules{}, fmt.Errorf("unexpected member type %T", z.Member)
+		}
+		maxKeys = append(maxKeys, key)
+	}
+	ruleSets, errDecode := decodeRuleSets(maxKeys)
+	if errDecode != nil {
+		return MostFrequentRules{}, errDecode
+	}
+	return MostFrequentRules{Count: int(top[0].Score), RuleSets: ruleSets}, nil
+}
+
+// Cardinality retrieves the numbers of members of the sorted sets
+func (rc *RedisCounter) Cardinality(ctx context.Context) (Cardinality, error) {
+	nbParams, errParams := rc.client.ZCard(ctx, rc.key).Result()
+	if errParams != nil {
+		return Cardinality{}, fmt.Errorf("retrieving params cardinality: %w", errParams)
+	}
+	nbRules, errRules := rc.client.ZCard(ctx, rc.rulesKey).Result()
+	if errRules != nil {
+		return Cardinality{}, fmt.Errorf("retrieving rules cardinality: %w", errRules)
+	}
+	return Cardinality{Params: int(nbParams), Rules: int(nbRules)}, nil
+}
+
+// Ping checks Redis is reachable
+func (rc *RedisCounter) Ping(ctx context.Context) error {
+	if errPing := rc.client.Ping(ctx).Err(); errPing != nil {
+		return fmt.Errorf("pinging redis: %w", errPing)
+	}
+	return nil
+}
+
+// Flush does nothing as every increment is sent to Redis
+func (rc *RedisCounter) Flush() error {
+	return nil
+}
+
+// withScore retrieves the members of the sorted set having exactly this score, up to count of them
+// if count is zero or less, all of them are retrieved
+func (rc *RedisCounter) withScore(ctx context.Context, key string, score float64, count int64) ([]redis.Z, error) {
+	scoreStr := strconv.FormatFloat(score, 'f', -1, 64)
+	rangeBy := &redis.ZRangeBy{Min: scoreStr, Max: scoreStr}
+	if count > 0 {
+		rangeBy.Offset, rangeBy.Count = 0, count
+	}
+	members, errRange := rc.client.ZRangeByScoreWithScores(ctx, key, rangeBy).Result()
+	if errRange != nil {
+		return nil, fmt.Errorf("retrieving members with score %s: %w", scoreStr, errRange)
+	}
+	return members, nil
+}
+
+// encodeMember gives the sorted set member of these parameters
+func encodeMember(params fizzbuzz.Params) (string, error) {
+	member, errJson := json.Marshal(params)
+	if errJson != nil {
+		return "", fmt.Errorf("marshalling json: %w", errJson)
+	}
+	return string(member), nil
+}
+
+// decodeMember converts a sorted set member and its score
+func decodeMember(z redis.Z) (ReqCount, error) {
+	member, ok := z.Member.(string)
+	if !ok {
+		return ReqCount{}, fmt.Errorf("unexpected member type %T", z.Member)
+	}
+	var params fizzbuzz.Params
+	if errJson := json.Unmarshal([]byte(member), &params); errJson != nil {
+		return ReqCount{}, fmt.Errorf("unmarshalling member %q: %w", member, errJson)
+	}
+	return ReqCount{Count: int(z.Score), Params: params}, nil
+}
@@ -0,0 +1,201 @@
+package stats
+
+import (
+	"context"
+	"fizzbuzz-server/internal/fizzbuzz"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRedisCounter creates a RedisCounter using an in-process Redis server, already incremented with these counts
+func newTestRedisCounter(t *testing.T, counts map[fizzbuzz.Params]int) *RedisCounter {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	rc := NewRedisCounter(client, "test")
+	for params, count := range counts {
+		for i := 0; i < count; i++ {
+			require.NoError(t, rc.Inc(context.Background(), params))
+		}
+	}
+	return rc
+}
+
+func Test_RedisCounter_IncGet(t *testing.T) {
+	assertions := assert.New(t)
+
+	params1 := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params2 := fizzbuzz.Params{Int1: 2, Int2: 7, Limit: 16, Str1: "fazz", Str2: "bozz"}
+	rc := newTestRedisCounter(t, map[fizzbuzz.Params]int{params1: 2})
+
+	got, gotErr := rc.Get(context.Background(), params1)
+	assertions.NoError(gotErr)
+	assertions.Equal(2, got, "wrong count for present params")
+
+	got, gotErr = rc.Get(context.Background(), params2)
+	assertions.NoError(gotErr)
+	assertions.Equal(0, got, "wrong count for missing params")
+
+	assertions.NoError(rc.Inc(context.Background(), params2))
+	got, gotErr = rc.Get(context.Background(), params2)
+	assertions.NoError(gotErr)
+	assertions.Equal(1, got, "wrong count after inc")
+}
+
+func Test_RedisCounter_MostFrequentReq(t *testing.T) {
+	params1 := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params2 := fizzbuzz.Params{Int1: 3, Int2: 6, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params3 := fizzbuzz.Params{Int1: 3, Int2: 7, Limit: 16, Str1: "fizz", Str2: "buzz"}
+
+	tests := map[string]struct {
+		counts map[fizzbuzz.Params]int
+		want   MostFrequentReq
+	}{
+		"empty": {
+			counts: map[fizzbuzz.Params]int{},
+			want:   MostFrequentReq{Count: 0, Params: []fizzbuzz.Params{}},
+		},
+		"one params": {
+			counts: map[fizzbuzz.Params]int{params1: 2, params2: 1},
+			want:   MostFrequentReq{Count: 2, Params: []fizzbuzz.Params{params1}},
+		},
+		"two params": {
+			counts: map[fizzbuzz.Params]int{params3: 2, params1: 2, params2: 1},
+			want:   MostFrequentReq{Count: 2, Params: []fizzbuzz.Params{params1, params3}},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rc := newTestRedisCounter(t, tt.counts)
+			got, gotErr := rc.MostFrequentReq(context.Background())
+			assert.NoError(t, gotErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_RedisCounter_TopK(t *testing.T) {
+	params1 := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params2 := fizzbuzz.Params{Int1: 3, Int2: 6, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params3 := fizzbuzz.Params{Int1: 3, Int2: 7, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params4 := fizzbuzz.Params{Int1: 2, Int2: 7, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params5 := fizzbuzz.Params{Int1: 10, Int2: 7, Limit: 16, Str1: "fizz", Str2: "buzz"}
+
+	tests := map[string]struct {
+		counts map[fizzbuzz.Params]int
+		k      int
+		want   []ReqCount
+	}{
+		"empty": {
+			counts: map[fizzbuzz.Params]int{},
+			k:      3,
+			want:   []ReqCount{},
+		},
+		"k greater than number of params": {
+			counts: map[fizzbuzz.Params]int{params1: 1, params2: 3},
+			k:      5,
+			want: []ReqCount{
+				{Count: 3, Params: params2},
+				{Count: 1, Params: params1},
+			},
+		},
+		// json encoded, params5 is lexicographically lower than params4, unlike the counter order
+		"ties sorted by params": {
+			counts: map[fizzbuzz.Params]int{params1: 2, params2: 4, params3: 2, params4: 2, params5: 2},
+			k:      3,
+			want: []ReqCount{
+				{Count: 4, Params: params2},
+				{Count: 2, Params: params4},
+				{Count: 2, Params: params1},
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rc := newTestRedisCounter(t, tt.counts)
+			got, gotErr := rc.TopK(context.Background(), tt.k)
+			assert.NoError(t, gotErr)
+			assert.Equal(t, tt.want, got)
+
+			// both implementations give the same result
+			fbcGot, _ := newFizzbuzzCounterFromCounts(tt.counts).TopK(context.Background(), tt.k)
+			assert.Equal(t, fbcGot, got)
+		})
+	}
+}
+
+func Test_RedisCounter_withScore(t *testing.T) {
+	counts := map[fizzbuzz.Params]int{}
+	for i := 1; i <= 5; i++ {
+		counts[fizzbuzz.Params{Int1: i, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}] = 2
+	}
+	counts[fizzbuzz.Params{Int1: 3, Int2: 6, Limit: 16, Str1: "fizz", Str2: "buzz"}] = 1
+	rc := newTestRedisCounter(t, counts)
+
+	got, gotErr := rc.withScore(context.Background(), rc.key, 2, 0)
+	assert.NoError(t, gotErr)
+	assert.Len(t, got, 5)
+	// the ties retrieved are bounded
+	got, gotErr = rc.withScore(context.Background(), rc.key, 2, 3)
+	assert.NoError(t, gotErr)
+	assert.Len(t, got, 3)
+}
+
+func Test_RedisCounter_MostFrequentRules(t *testing.T) {
+	assertions := assert.New(t)
+
+	ruleSet1 := fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}, {Divisor: 7, Word: "bazz"}}, Limit: 16}
+	ruleSet2 := fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 2, Word: "fizz"}}, Limit: 16}
+	rc := newTestRedisCounter(t, map[fizzbuzz.Params]int{})
+
+	got, gotErr := rc.MostFrequentRules(context.Background())
+	assertions.NoError(gotErr)
+	assertions.Equal(MostFrequentRules{Count: 0, RuleSets: []fizzbuzz.RuleSet{}}, got, "wrong result when empty")
+
+	for _, ruleSet := range []fizzbuzz.RuleSet{ruleSet1, ruleSet2, ruleSet1, ruleSet2} {
+		assertions.NoError(rc.IncRules(context.Background(), ruleSet))
+	}
+	got, gotErr = rc.MostFrequentRules(context.Background())
+	assertions.NoError(gotErr)
+	assertions.Equal(MostFrequentRules{Count: 2, RuleSets: []fizzbuzz.RuleSet{ruleSet2, ruleSet1}}, got, "wrong result")
+
+	// rule sets are counted apart from the params
+	gotMostFreqReq, _ := rc.MostFrequentReq(context.Background())
+	assertions.Equal(0, gotMostFreqReq.Count)
+}
+
+func Test_RedisCounter_Cardinality(t *testing.T) {
+	assertions := assert.New(t)
+
+	params1 := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params2 := fizzbuzz.Params{Int1: 2, Int2: 7, Limit: 16, Str1: "fazz", Str2: "bozz"}
+	rc := newTestRedisCounter(t, map[fizzbuzz.Params]int{params1: 2, params2: 1})
+	assertions.NoError(rc.IncRules(context.Background(), params1.RuleSet()))
+
+	got, gotErr := rc.Cardinality(context.Background())
+	assertions.NoError(gotErr)
+	assertions.Equal(Cardinality{Params: 2, Rules: 1}, got)
+}
+
+func Test_RedisCounter_Ping(t *testing.T) {
+	rc := newTestRedisCounter(t, map[fizzbuzz.Params]int{})
+	assert.NoError(t, rc.Ping(context.Background()))
+}
+
+func Test_RedisCounter_unreachable(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	defer client.Close()
+	rc := NewRedisCounter(client, "test")
+	server.Close()
+
+	assert.Error(t, rc.Inc(context.Background(), fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16}))
+	_, errMostFreq := rc.MostFrequentReq(context.Background())
+	assert.Error(t, errMostFreq)
+	assert.Error(t, rc.Ping(context.Background()))
+}
@@ -1,49 +1,328 @@
 package stats
 
-import "fizzbuzz-server/internal/fizzbuzz"
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
 
-// FizzbuzzCounter keeps count of the number of request for a set of parameters
-type FizzbuzzCounter map[fizzbuzz.Params]int
+	"fizzbuzz-server/internal/fizzbuzz"
+)
+
+// Counter keeps count of the number of request for a set of parameters
+// Implementations must be safe for concurrent use
+type Counter interface {
+	// Inc increments the counter for these parameters
+	Inc(ctx context.Context, params fizzbuzz.Params) error
+	// Get retrieves the numbers of request received for these parameters
+	Get(ctx context.Context, params fizzbuzz.Params) (int, error)
+	// MostFrequentReq retrieves the number and the parameters (one or multiple) of the most frequent request
+	MostFrequentReq(ctx context.Context) (MostFrequentReq, error)
+	// TopK retrieves the k most frequent requests, sorted by descending count then ascending parameters
+	TopK(ctx context.Context, k int) ([]ReqCount, error)
+	// IncRules increments the counter for this rule set, counted apart from the parameters
+	// the page of the output requested, if any, is carried by the context
+	IncRules(ctx context.Context, ruleSet fizzbuzz.RuleSet) error
+	// MostFrequentRules retrieves the number and the rule sets (one or multiple) of the most frequent generalized request
+	MostFrequentRules(ctx context.Context) (MostFrequentRules, error)
+	// Cardinality retrieves the numbers of distinct parameters and rule sets counted
+	Cardinality(ctx context.Context) (Cardinality, error)
+	// Ping checks the backend keeping the counts is reachable
+	Ping(ctx context.Context) error
+	// Flush persists the counts kept in memory, if any
+	Flush() error
+}
+
+// FizzbuzzCounter is the in-memory implementation of Counter
+// It is safe for concurrent use
+// If a storage is set, each increment is persisted in it before being counted
+type FizzbuzzCounter struct {
+	mu     sync.RWMutex
+	params tally[fizzbuzz.Params]
+	rules  tally[string]
+	// storageMu serializes the writes to the storage, so the readers only wait for the counts and not for the disk
+	// it is taken before mu when both are needed
+	storageMu sync.Mutex
+	storage   Storage
+}
+
+var _ Counter = (*FizzbuzzCounter)(nil)
 
 type MostFrequentReq struct {
 	Count  int               `json:"count"`
 	Params []fizzbuzz.Params `json:"params"`
 }
 
-func NewFizzbuzzCounter() FizzbuzzCounter {
-	return make(map[fizzbuzz.Params]int)
+// ReqCount is the number of requests received for a set of parameters
+type ReqCount struct {
+	Count  int             `json:"count"`
+	Params fizzbuzz.Params `json:"params"`
+}
+
+// MostFrequentRules is the number and the rule sets of the most frequent generalized request
+type MostFrequentRules struct {
+	Count    int                `json:"count"`
+	RuleSets []fizzbuzz.RuleSet `json:"ruleSets"`
+}
+
+// Cardinality is the number of distinct parameters and the number of distinct rule sets counted
+type Cardinality struct {
+	Params int
+	Rules  int
+}
+
+// Counts are the numbers of requests received for each set of parameters and each rule set, indexed by its key
+type Counts struct {
+	Params map[fizzbuzz.Params]int
+	Rules  map[string]int
+}
+
+// pageKey is the context key of the page of the output requested
+type pageKey struct{}
+
+// NewPageContext gives a copy of the context carrying the page of the output requested
+func NewPageContext(ctx context.Context, page fizzbuzz.Page) context.Context {
+	return context.WithValue(ctx, pageKey{}, page)
+}
+
+// PageFromContext gives the page of the output requested, ok is false if the whole output is requested
+func PageFromContext(ctx context.Context) (page fizzbuzz.Page, ok bool) {
+	page, ok = ctx.Value(pageKey{}).(fizzbuzz.Page)
+	return page, ok
+}
+
+func NewFizzbuzzCounter() *FizzbuzzCounter {
+	return &FizzbuzzCounter{
+		params: newTally[fizzbuzz.Params](),
+		rules:  newTally[string](),
+	}
+}
+
+// LoadFizzbuzzCounter creates a counter initialized with the counts persisted in the storage
+// the loaded counts are flushed right away so the storage starts from a compacted state
+func LoadFizzbuzzCounter(storage Storage) (*FizzbuzzCounter, error) {
+	counts, errLoad := storage.Load()
+	if errLoad != nil {
+		return nil, fmt.Errorf("loading counts: %w", errLoad)
+	}
+	if errFlush := storage.Flush(counts); errFlush != nil {
+		return nil, fmt.Errorf("flushing counts: %w", errFlush)
+	}
+
+	fbc := NewFizzbuzzCounter()
+	for params, count := range counts.Params {
+		fbc.params.add(params, count)
+	}
+	for key, count := range counts.Rules {
+		fbc.rules.add(key, count)
+	}
+	fbc.storage = storage
+	return fbc, nil
 }
 
 // Inc increments the counter for these parameters
-func (fbc FizzbuzzCounter) Inc(params fizzbuzz.Params) {
-	_, found := fbc[params]
-	if !found {
-		fbc[params] = 0
+// the request is not counted if it could not be persisted
+func (fbc *FizzbuzzCounter) Inc(_ context.Context, params fizzbuzz.Params) error {
+	if fbc.storage != nil {
+		fbc.storageMu.Lock()
+		defer fbc.storageMu.Unlock()
+		if errAppend := fbc.storage.Append(params); errAppend != nil {
+			return fmt.Errorf("persisting request: %w", errAppend)
+		}
 	}
-	fbc[params]++
+	fbc.mu.Lock()
+	defer fbc.mu.Unlock()
+	fbc.params.add(params, 1)
+	return nil
 }
 
-// Get retrieve the numbers of request received for these parameters
-func (fbc FizzbuzzCounter) Get(params fizzbuzz.Params) int {
-	_, found := fbc[params]
-	if !found {
-		return 0
+// IncRules increments the counter for this rule set
+// the request is not counted if it could not be persisted
+func (fbc *FizzbuzzCounter) IncRules(_ context.Context, ruleSet fizzbuzz.RuleSet) error {
+	if fbc.storage != nil {
+		fbc.storageMu.Lock()
+		defer fbc.storageMu.Unlock()
+		if errAppend := fbc.storage.AppendRules(ruleSet); errAppend != nil {
+			return fmt.Errorf("persisting request: %w", errAppend)
+		}
 	}
-	return fbc[params]
+	fbc.mu.Lock()
+	defer fbc.mu.Unlock()
+	fbc.rules.add(ruleSet.Key(), 1)
+	return nil
+}
+
+// Flush persists all the counts in the storage, if any
+func (fbc *FizzbuzzCounter) Flush() error {
+	if fbc.storage == nil {
+		return nil
+	}
+	// the storage lock prevents any increment from being appended while the storage is compacted,
+	// the read lock lets the readers go on meanwhile
+	fbc.storageMu.Lock()
+	defer fbc.storageMu.Unlock()
+	fbc.mu.RLock()
+	defer fbc.mu.RUnlock()
+	return fbc.storage.Flush(Counts{Params: fbc.params.counts, Rules: fbc.rules.counts})
+}
+
+// Ping checks the storage is usable, if any
+func (fbc *FizzbuzzCounter) Ping(_ context.Context) error {
+	if fbc.storage == nil {
+		return nil
+	}
+	return fbc.storage.Ping()
+}
+
+// Get retrieve the numbers of request received for these parameters
+func (fbc *FizzbuzzCounter) Get(_ context.Context, params fizzbuzz.Params) (int, error) {
+	fbc.mu.RLock()
+	defer fbc.mu.RUnlock()
+	return fbc.params.counts[params], nil
 }
 
 // MostFrequentReq retrieves the number and the parameters (one or multiple) of the most frequent request
-func (fbc FizzbuzzCounter) MostFrequentReq() MostFrequentReq {
-	max := 0
-	maxParams := []fizzbuzz.Params{}
-	for params, count := range fbc {
-		if count > max {
-			max = count
-			maxParams = []fizzbuzz.Params{}
-			maxParams = append(maxParams, params)
-		} else if count == max {
-			maxParams = append(maxParams, params)
+func (fbc *FizzbuzzCounter) MostFrequentReq(_ context.Context) (MostFrequentReq, error) {
+	fbc.mu.RLock()
+	defer fbc.mu.RUnlock()
+	maxParams := make([]fizzbuzz.Params, 0, len(fbc.params.maxKeys))
+	for params := range fbc.params.maxKeys {
+		maxParams = append(maxParams, params)
+	}
+	sort.Slice(maxParams, func(i, j int) bool { return paramsLess(maxParams[i], maxParams[j]) })
+	return MostFrequentReq{Count: fbc.params.max, Params: maxParams}, nil
+}
+
+// MostFrequentRules retrieves the number and the rule sets (one or multiple) of the most frequent generalized request
+// rule sets with the same count are sorted by ascending key
+func (fbc *FizzbuzzCounter) MostFrequentRules(_ context.Context) (MostFrequentRules, error) {
+	fbc.mu.RLock()
+	maxKeys := make([]string, 0, len(fbc.rules.maxKeys))
+	for key := range fbc.rules.maxKeys {
+		maxKeys = append(maxKeys, key)
+	}
+	max := fbc.rules.max
+	fbc.mu.RUnlock()
+
+	ruleSets, errDecode := decodeRuleSets(maxKeys)
+	if errDecode != nil {
+		return MostFrequentRules{}, errDecode
+	}
+	return MostFrequentRules{Count: max, RuleSets: ruleSets}, nil
+}
+
+// Cardinality retrieves the numbers of distinct parameters and rule sets counted
+func (fbc *FizzbuzzCounter) Cardinality(_ context.Context) (Cardinality, error) {
+	fbc.mu.RLock()
+	defer fbc.mu.RUnlock()
+	return Cardinality{Params: len(fbc.params.counts), Rules: len(fbc.rules.counts)}, nil
+}
+
+// TopK retrieves the k most frequent requests, sorted by descending count
+// requests with the same count are sorted by ascending parameters (int1, int2, limit, str1 then str2)
+func (fbc *FizzbuzzCounter) TopK(_ context.Context, k int) ([]ReqCount, error) {
+	if k <= 0 {
+		return []ReqCount{}, nil
+	}
+
+	fbc.mu.RLock()
+	// keep the k greatest requests in a min-heap so its root is the first to be dropped
+	top := make(reqCountHeap, 0, k)
+	for params, count := range fbc.params.counts {
+		reqCount := ReqCount{Count: count, Params: params}
+		if len(top) < k {
+			heap.Push(&top, reqCount)
+		} else if reqCountLess(top[0], reqCount) {
+			top[0] = reqCount
+			heap.Fix(&top, 0)
 		}
 	}
-	return MostFrequentReq{Count: max, Params: maxParams}
+	fbc.mu.RUnlock()
+
+	sort.Slice(top, func(i, j int) bool { return reqCountLess(top[j], top[i]) })
+	return top, nil
+}
+
+// decodeRuleSets converts rule set keys to rule sets, sorted by ascending key
+func decodeRuleSets(keys []string) ([]fizzbuzz.RuleSet, error) {
+	sort.Strings(keys)
+	ruleSets := make([]fizzbuzz.RuleSet, 0, len(keys))
+	for _, key := range keys {
+		var ruleSet fizzbuzz.RuleSet
+		if errJson := json.Unmarshal([]byte(key), &ruleSet); errJson != nil {
+			return nil, fmt.Errorf("decoding rule set %q: %w", key, errJson)
+		}
+		ruleSets = append(ruleSets, ruleSet)
+	}
+	return ruleSets, nil
+}
+
+// reqCountLess reports whether a ranks below b
+func reqCountLess(a, b ReqCount) bool {
+	if a.Count != b.Count {
+		return a.Count < b.Count
+	}
+	return paramsLess(b.Params, a.Params)
+}
+
+// paramsLess gives a deterministic order to the parameters, field by field
+func paramsLess(a, b fizzbuzz.Params) bool {
+	if a.Int1 != b.Int1 {
+		return a.Int1 < b.Int1
+	}
+	if a.Int2 != b.Int2 {
+		return a.Int2 < b.Int2
+	}
+	if a.Limit != b.Limit {
+		return a.Limit < b.Limit
+	}
+	if a.Str1 != b.Str1 {
+		return a.Str1 < b.Str1
+	}
+	return a.Str2 < b.Str2
+}
+
+// tally counts the occurrences of keys
+// the most frequent keys are tracked on each increment so they can be retrieved without scanning all the counts
+type tally[K comparable] struct {
+	counts  map[K]int
+	max     int
+	maxKeys map[K]struct{}
+}
+
+func newTally[K comparable]() tally[K] {
+	return tally[K]{
+		counts:  make(map[K]int),
+		maxKeys: make(map[K]struct{}),
+	}
+}
+
+// add increases the count of this key by n, n must be positive
+func (t *tally[K]) add(key K, n int) {
+	t.counts[key] += n
+	count := t.counts[key]
+	if count > t.max {
+		t.max = count
+		t.maxKeys = map[K]struct{}{key: {}}
+	} else if count == t.max {
+		t.maxKeys[key] = struct{}{}
+	}
+}
+
+// reqCountHeap is a min-heap of requests, implementing heap.Interface
+type reqCountHeap []ReqCount
+
+func (h reqCountHeap) Len() int           { return len(h) }
+func (h reqCountHeap) Less(i, j int) bool { return reqCountLess(h[i], h[j]) }
+func (h reqCountHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *reqCountHeap) Push(x any) { *h = append(*h, x.(ReqCount)) }
+
+func (h *reqCountHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
 }
@@ -1,20 +1,24 @@
 package stats
 
 import (
+	"context"
 	"fizzbuzz-server/internal/fizzbuzz"
+	"math/rand"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_FizzbuzzCounter_Inc(t *testing.T) {
 	tests := map[string]struct {
-		fbc    FizzbuzzCounter
+		fbc    *FizzbuzzCounter
 		params fizzbuzz.Params
 		want   int
 	}{
 		"new params": {
-			fbc: FizzbuzzCounter{
+			fbc: newFizzbuzzCounterFromCounts(map[fizzbuzz.Params]int{
 				{
 					Int1:  3,
 					Int2:  5,
@@ -22,7 +26,7 @@ func Test_FizzbuzzCounter_Inc(t *testing.T) {
 					Str1:  "fizz",
 					Str2:  "buzz",
 				}: 1,
-			},
+			}),
 			params: fizzbuzz.Params{
 				Int1:  2,
 				Int2:  5,
@@ -33,7 +37,7 @@ func Test_FizzbuzzCounter_Inc(t *testing.T) {
 			want: 1,
 		},
 		"already present in map": {
-			fbc: FizzbuzzCounter{
+			fbc: newFizzbuzzCounterFromCounts(map[fizzbuzz.Params]int{
 				{
 					Int1:  3,
 					Int2:  5,
@@ -41,7 +45,7 @@ func Test_FizzbuzzCounter_Inc(t *testing.T) {
 					Str1:  "fizz",
 					Str2:  "buzz",
 				}: 1,
-			},
+			}),
 			params: fizzbuzz.Params{
 				Int1:  3,
 				Int2:  5,
@@ -56,8 +60,8 @@ func Test_FizzbuzzCounter_Inc(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			assertions := assert.New(t)
 
-			tt.fbc.Inc(tt.params)
-			got, ok := tt.fbc[tt.params]
+			assertions.NoError(tt.fbc.Inc(context.Background(), tt.params))
+			got, ok := tt.fbc.params.counts[tt.params]
 			assertions.True(ok, "key not found")
 			assertions.Equal(tt.want, got, "wrong value")
 		})
@@ -66,12 +70,12 @@ func Test_FizzbuzzCounter_Inc(t *testing.T) {
 
 func Test_FizzbuzzCounter_Get(t *testing.T) {
 	tests := map[string]struct {
-		fbc    FizzbuzzCounter
+		fbc    *FizzbuzzCounter
 		params fizzbuzz.Params
 		want   int
 	}{
 		"not present in map": {
-			fbc: map[fizzbuzz.Params]int{
+			fbc: newFizzbuzzCounterFromCounts(map[fizzbuzz.Params]int{
 				{
 					Int1:  3,
 					Int2:  5,
@@ -79,7 +83,7 @@ func Test_FizzbuzzCounter_Get(t *testing.T) {
 					Str1:  "fizz",
 					Str2:  "buzz",
 				}: 1,
-			},
+			}),
 			params: fizzbuzz.Params{
 				Int1:  2,
 				Int2:  5,
@@ -90,7 +94,7 @@ func Test_FizzbuzzCounter_Get(t *testing.T) {
 			want: 0,
 		},
 		"present in map": {
-			fbc: map[fizzbuzz.Params]int{
+			fbc: newFizzbuzzCounterFromCounts(map[fizzbuzz.Params]int{
 				{
 					Int1:  3,
 					Int2:  5,
@@ -98,7 +102,7 @@ func Test_FizzbuzzCounter_Get(t *testing.T) {
 					Str1:  "fizz",
 					Str2:  "buzz",
 				}: 1,
-			},
+			}),
 			params: fizzbuzz.Params{
 				Int1:  3,
 				Int2:  5,
@@ -113,7 +117,8 @@ func Test_FizzbuzzCounter_Get(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			assertions := assert.New(t)
 
-			got := tt.fbc.Get(tt.params)
+			got, gotErr := tt.fbc.Get(context.Background(), tt.params)
+			assertions.NoError(gotErr)
 			assertions.Equal(tt.want, got, "wrong value")
 		})
 	}
@@ -121,11 +126,11 @@ func Test_FizzbuzzCounter_Get(t *testing.T) {
 
 func Test_FizzbuzzCounter_MostFrequentRequest(t *testing.T) {
 	tests := map[string]struct {
-		fbc  FizzbuzzCounter
+		fbc  *FizzbuzzCounter
 		want MostFrequentReq
 	}{
 		"one params": {
-			fbc: map[fizzbuzz.Params]int{
+			fbc: newFizzbuzzCounterFromCounts(map[fizzbuzz.Params]int{
 				{
 					Int1:  3,
 					Int2:  5,
@@ -140,7 +145,7 @@ func Test_FizzbuzzCounter_MostFrequentRequest(t *testing.T) {
 					Str1:  "fizz",
 					Str2:  "buzz",
 				}: 1,
-			},
+			}),
 			want: MostFrequentReq{
 				Count: 2,
 				Params: []fizzbuzz.Params{
@@ -155,7 +160,7 @@ func Test_FizzbuzzCounter_MostFrequentRequest(t *testing.T) {
 			},
 		},
 		"two params": {
-			fbc: map[fizzbuzz.Params]int{
+			fbc: newFizzbuzzCounterFromCounts(map[fizzbuzz.Params]int{
 				{
 					Int1:  3,
 					Int2:  5,
@@ -177,7 +182,7 @@ func Test_FizzbuzzCounter_MostFrequentRequest(t *testing.T) {
 					Str1:  "fizz",
 					Str2:  "buzz",
 				}: 1,
-			},
+			}),
 			want: MostFrequentReq{
 				Count: 2,
 				Params: []fizzbuzz.Params{
@@ -203,9 +208,295 @@ func Test_FizzbuzzCounter_MostFrequentRequest(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			assertions := assert.New(t)
 
-			got := tt.fbc.MostFrequentReq()
+			got, gotErr := tt.fbc.MostFrequentReq(context.Background())
+			assertions.NoError(gotErr)
 			assertions.Equal(tt.want.Count, got.Count, "count different")
 			assertions.ElementsMatch(tt.want.Params, got.Params, "params different")
 		})
 	}
 }
+
+func Test_FizzbuzzCounter_Concurrency(t *testing.T) {
+	assertions := assert.New(t)
+
+	const nbGoroutines = 1000
+	params1 := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params2 := fizzbuzz.Params{Int1: 2, Int2: 7, Limit: 16, Str1: "fazz", Str2: "bozz"}
+	fbc := NewFizzbuzzCounter()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < nbGoroutines; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			fbc.Inc(context.Background(), params1)
+		}()
+		go func() {
+			defer wg.Done()
+			fbc.Inc(context.Background(), params2)
+		}()
+		go func() {
+			defer wg.Done()
+			fbc.Get(context.Background(), params1)
+		}()
+		go func() {
+			defer wg.Done()
+			fbc.MostFrequentReq(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	gotCount1, _ := fbc.Get(context.Background(), params1)
+	assertions.Equal(nbGoroutines, gotCount1, "wrong count for params1")
+	gotCount2, _ := fbc.Get(context.Background(), params2)
+	assertions.Equal(nbGoroutines, gotCount2, "wrong count for params2")
+	got, _ := fbc.MostFrequentReq(context.Background())
+	assertions.Equal(nbGoroutines, got.Count, "wrong most frequent count")
+	assertions.ElementsMatch([]fizzbuzz.Params{params1, params2}, got.Params, "wrong most frequent params")
+}
+
+func Test_FizzbuzzCounter_MostFrequentReq_tracking(t *testing.T) {
+	assertions := assert.New(t)
+
+	fbc := NewFizzbuzzCounter()
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		fbc.Inc(context.Background(), fizzbuzz.Params{Int1: rnd.Intn(20) + 1, Int2: rnd.Intn(20) + 1, Limit: 16})
+
+		got, _ := fbc.MostFrequentReq(context.Background())
+		want := scanMostFrequentReq(fbc.params.counts)
+		assertions.Equal(want.Count, got.Count, "count different after %d inc", i+1)
+		assertions.ElementsMatch(want.Params, got.Params, "params different after %d inc", i+1)
+	}
+}
+
+// scanMostFrequentReq computes the most frequent request by scanning all the counts
+// it is the reference implementation used to check and benchmark FizzbuzzCounter.MostFrequentReq
+func scanMostFrequentReq(counts map[fizzbuzz.Params]int) MostFrequentReq {
+	max := 0
+	maxParams := []fizzbuzz.Params{}
+	for params, count := range counts {
+		if count > max {
+			max = count
+			maxParams = []fizzbuzz.Params{params}
+		} else if count == max {
+			maxParams = append(maxParams, params)
+		}
+	}
+	return MostFrequentReq{Count: max, Params: maxParams}
+}
+
+// benchmarkCounter creates a counter with nbKeys distinct params, one of them being the most frequent
+func benchmarkCounter(nbKeys int) *FizzbuzzCounter {
+	fbc := NewFizzbuzzCounter()
+	for i := 1; i <= nbKeys; i++ {
+		fbc.Inc(context.Background(), fizzbuzz.Params{Int1: 3, Int2: 5, Limit: i, Str1: "fizz", Str2: "buzz"})
+	}
+	fbc.Inc(context.Background(), fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 1, Str1: "fizz", Str2: "buzz"})
+	return fbc
+}
+
+func Benchmark_FizzbuzzCounter_MostFrequentReq(b *testing.B) {
+	fbc := benchmarkCounter(1_000_000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fbc.MostFrequentReq(context.Background())
+	}
+}
+
+func Benchmark_FizzbuzzCounter_MostFrequentReq_scan(b *testing.B) {
+	fbc := benchmarkCounter(1_000_000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scanMostFrequentReq(fbc.params.counts)
+	}
+}
+
+func Benchmark_FizzbuzzCounter_Inc(b *testing.B) {
+	fbc := benchmarkCounter(1_000_000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fbc.Inc(context.Background(), fizzbuzz.Params{Int1: 3, Int2: 5, Limit: i%1_000_000 + 1, Str1: "fizz", Str2: "buzz"})
+	}
+}
+
+func Test_FizzbuzzCounter_TopK(t *testing.T) {
+	params1 := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params2 := fizzbuzz.Params{Int1: 3, Int2: 6, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params3 := fizzbuzz.Params{Int1: 3, Int2: 7, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params4 := fizzbuzz.Params{Int1: 2, Int2: 7, Limit: 16, Str1: "fizz", Str2: "buzz"}
+
+	tests := map[string]struct {
+		fbc  *FizzbuzzCounter
+		k    int
+		want []ReqCount
+	}{
+		"empty counter": {
+			fbc:  NewFizzbuzzCounter(),
+			k:    3,
+			want: []ReqCount{},
+		},
+		"k zero": {
+			fbc:  newFizzbuzzCounterFromCounts(map[fizzbuzz.Params]int{params1: 1}),
+			k:    0,
+			want: []ReqCount{},
+		},
+		"k greater than number of params": {
+			fbc: newFizzbuzzCounterFromCounts(map[fizzbuzz.Params]int{
+				params1: 1,
+				params2: 3,
+			}),
+			k: 5,
+			want: []ReqCount{
+				{Count: 3, Params: params2},
+				{Count: 1, Params: params1},
+			},
+		},
+		"ties sorted by params": {
+			fbc: newFizzbuzzCounterFromCounts(map[fizzbuzz.Params]int{
+				params1: 2,
+				params2: 4,
+				params3: 2,
+				params4: 2,
+			}),
+			k: 3,
+			want: []ReqCount{
+				{Count: 4, Params: params2},
+				{Count: 2, Params: params4},
+				{Count: 2, Params: params1},
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, gotErr := tt.fbc.TopK(context.Background(), tt.k)
+			assert.NoError(t, gotErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_LoadFizzbuzzCounter(t *testing.T) {
+	assertions := assert.New(t)
+
+	params1 := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params2 := fizzbuzz.Params{Int1: 2, Int2: 7, Limit: 16, Str1: "fazz", Str2: "bozz"}
+	ruleSet := fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}, {Divisor: 7, Word: "bazz"}}, Limit: 16}
+	dir := t.TempDir()
+
+	// first run: count some requests then flush
+	storage, errStorage := NewFileStorage(dir)
+	require.NoError(t, errStorage)
+	fbc, errLoad := LoadFizzbuzzCounter(storage)
+	require.NoError(t, errLoad)
+	assertions.NoError(fbc.Inc(context.Background(), params1))
+	assertions.NoError(fbc.Inc(context.Background(), params1))
+	assertions.NoError(fbc.Flush())
+	assertions.NoError(fbc.Inc(context.Background(), params2))
+	assertions.NoError(fbc.IncRules(context.Background(), ruleSet))
+	assertions.NoError(storage.Close())
+
+	// second run: flushed and appended requests are both restored
+	storage, errStorage = NewFileStorage(dir)
+	require.NoError(t, errStorage)
+	defer storage.Close()
+	fbc, errLoad = LoadFizzbuzzCounter(storage)
+	require.NoError(t, errLoad)
+	gotCount1, _ := fbc.Get(context.Background(), params1)
+	assertions.Equal(2, gotCount1)
+	gotCount2, _ := fbc.Get(context.Background(), params2)
+	assertions.Equal(1, gotCount2)
+	gotMostFreqReq, _ := fbc.MostFrequentReq(context.Background())
+	assertions.Equal(MostFrequentReq{Count: 2, Params: []fizzbuzz.Params{params1}}, gotMostFreqReq)
+	gotMostFreqRules, _ := fbc.MostFrequentRules(context.Background())
+	assertions.Equal(MostFrequentRules{Count: 1, RuleSets: []fizzbuzz.RuleSet{ruleSet}}, gotMostFreqRules)
+}
+
+func Test_FizzbuzzCounter_persistFailure(t *testing.T) {
+	assertions := assert.New(t)
+
+	params := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	ruleSet := fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}}, Limit: 16}
+	storage, errStorage := NewFileStorage(t.TempDir())
+	require.NoError(t, errStorage)
+	fbc, errLoad := LoadFizzbuzzCounter(storage)
+	require.NoError(t, errLoad)
+
+	// the closed log can't be written, so the requests are not counted
+	assertions.NoError(storage.Close())
+	assertions.Error(fbc.Inc(context.Background(), params))
+	assertions.Error(fbc.IncRules(context.Background(), ruleSet))
+	gotCount, _ := fbc.Get(context.Background(), params)
+	assertions.Equal(0, gotCount)
+	gotCardinality, _ := fbc.Cardinality(context.Background())
+	assertions.Equal(Cardinality{}, gotCardinality)
+}
+
+func Test_FizzbuzzCounter_MostFrequentRules(t *testing.T) {
+	ruleSet1 := fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 3, Word: "fizz"}, {Divisor: 7, Word: "bazz"}}, Limit: 16}
+	ruleSet2 := fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 2, Word: "fizz"}}, Limit: 16}
+	ruleSet3 := fizzbuzz.RuleSet{Rules: []fizzbuzz.Rule{{Divisor: 2, Word: "fizz"}}, Limit: 20}
+
+	tests := map[string]struct {
+		incs []fizzbuzz.RuleSet
+		want MostFrequentRules
+	}{
+		"empty": {
+			incs: []fizzbuzz.RuleSet{},
+			want: MostFrequentRules{Count: 0, RuleSets: []fizzbuzz.RuleSet{}},
+		},
+		"one rule set": {
+			incs: []fizzbuzz.RuleSet{ruleSet1, ruleSet2, ruleSet1},
+			want: MostFrequentRules{Count: 2, RuleSets: []fizzbuzz.RuleSet{ruleSet1}},
+		},
+		"ties sorted by key": {
+			incs: []fizzbuzz.RuleSet{ruleSet3, ruleSet1, ruleSet2},
+			want: MostFrequentRules{Count: 1, RuleSets: []fizzbuzz.RuleSet{ruleSet2, ruleSet3, ruleSet1}},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			fbc := NewFizzbuzzCounter()
+			for _, ruleSet := range tt.incs {
+				assertions.NoError(fbc.IncRules(context.Background(), ruleSet))
+			}
+			got, gotErr := fbc.MostFrequentRules(context.Background())
+			assertions.NoError(gotErr)
+			assertions.Equal(tt.want, got)
+
+			// rule sets are counted apart from the params
+			gotMostFreqReq, _ := fbc.MostFrequentReq(context.Background())
+			assertions.Equal(0, gotMostFreqReq.Count)
+		})
+	}
+}
+
+func Test_FizzbuzzCounter_Cardinality(t *testing.T) {
+	assertions := assert.New(t)
+
+	fbc := NewFizzbuzzCounter()
+	got, gotErr := fbc.Cardinality(context.Background())
+	assertions.NoError(gotErr)
+	assertions.Equal(Cardinality{Params: 0, Rules: 0}, got)
+
+	params1 := fizzbuzz.Params{Int1: 3, Int2: 5, Limit: 16, Str1: "fizz", Str2: "buzz"}
+	params2 := fizzbuzz.Params{Int1: 2, Int2: 7, Limit: 16, Str1: "fazz", Str2: "bozz"}
+	for _, params := range []fizzbuzz.Params{params1, params2, params1} {
+		assertions.NoError(fbc.Inc(context.Background(), params))
+	}
+	assertions.NoError(fbc.IncRules(context.Background(), params1.RuleSet()))
+	got, gotErr = fbc.Cardinality(context.Background())
+	assertions.NoError(gotErr)
+	assertions.Equal(Cardinality{Params: 2, Rules: 1}, got)
+}
+
+// newFizzbuzzCounterFromCounts creates a counter initialized with existing counts
+func newFizzbuzzCounterFromCounts(counts map[fizzbuzz.Params]int) *FizzbuzzCounter {
+	fbc := NewFizzbuzzCounter()
+	for params, count := range counts {
+		fbc.params.add(params, count)
+	}
+	return fbc
+}
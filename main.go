@@ -5,16 +5,26 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
 	"fizzbuzz-server/api"
 	"fizzbuzz-server/config"
+	"fizzbuzz-server/internal/stats"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run starts the server until a shutdown signal is received and gives the exit code of the program
+// it is not zero if the server failed or did not shut down cleanly
+func run() int {
 	conf, errConf := config.InitEnvConf()
 	if errConf != nil {
 		panic(fmt.Errorf("error while initializing configuration: %w", errConf))
@@ -27,20 +37,142 @@ func main() {
 	}
 	zerolog.SetGlobalLevel(logLevel)
 
-	api := api.Init(conf)
+	counter, closeCounter, errCounter := initCounter(conf)
+	if errCounter != nil {
+		panic(fmt.Errorf("error while initializing counter: %w", errCounter))
+	}
 
+	api := api.Init(conf, counter)
+	// the counter resources are released once its counts are flushed
+	api.OnShutdown(closeCounter)
+
+	servErrChan := make(chan error, 1)
 	go func() {
-		if errServ := api.Run(); errServ != nil {
-			log.Warn().Err(errServ).Msg("server exited")
-		}
+		servErrChan <- api.Run()
 	}()
 
 	stopChan := make(chan os.Signal, 1)
-	signal.Notify(stopChan, os.Interrupt)
+	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
 
-	<-stopChan
+	exitCode := 0
+	select {
+	case sig := <-stopChan:
+		log.Info().Str("signal", sig.String()).Msg("shutdown signal received")
+	case errServ := <-servErrChan:
+		// the server stopped by itself, still shut down to persist the counts
+		log.Error().Err(errServ).Msg("server exited")
+		exitCode = 1
+	}
+
+	// the drain delay is part of the shutdown window
+	ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+	if errShutdown := api.Shutdown(ctx); errShutdown != nil {
+		log.Error().Err(errShutdown).Msg("error while shutting down")
+		return 1
+	}
+	log.Info().Msg("server shut down")
+	return exitCode
+}
+
+// initCounter creates the request counter using the configured backend
+// the returned func releases the resources used by the counter
+func initCounter(conf config.Conf) (stats.Counter, func() error, error) {
+	switch conf.CounterBackend {
+	case config.CounterBackendRedis:
+		return initRedisCounter(conf)
+	default:
+		return initMemoryCounter(conf)
+	}
+}
+
+// initMemoryCounter creates an in-memory counter, reloading the persisted counts if a stats directory is configured
+// the counts of each API key are then persisted in the directory '<dir>/keys/<name>'
+func initMemoryCounter(conf config.Conf) (stats.Counter, func() error, error) {
+	if conf.StatsDir == "" {
+		newKeyCounter := func(string) stats.Counter { return stats.NewFizzbuzzCounter() }
+		return stats.NewKeyedCounter(stats.NewFizzbuzzCounter(), newKeyCounter), func() error { return nil }, nil
+	}
+
+	var storages []*stats.FileStorage
+	closeStorages := func() error {
+		var errFirst error
+		for _, storage := range storages {
+			if errClose := storage.Close(); errClose != nil && errFirst == nil {
+				errFirst = fmt.Errorf("closing counter storage: %w", errClose)
+			}
+		}
+		return errFirst
+	}
+
+	counter, storage, errLoad := loadFileCounter(conf.StatsDir)
+	if errLoad != nil {
+		return nil, nil, errLoad
+	}
+	storages = append(storages, storage)
+
+	// the keys are known from the start, so their counters are loaded now rather than on their first request
+	keyCounters := make(map[string]stats.Counter, len(conf.ParsedAPIKeys))
+	for _, key := range conf.ParsedAPIKeys {
+		keyCounter, keyStorage, errLoadKey := loadFileCounter(filepath.Join(conf.StatsDir, "keys", key.Name))
+		if errLoadKey != nil {
+			closeStorages()
+			return nil, nil, fmt.Errorf("key %s: %w", key.Name, errLoadKey)
+		}
+		storages = append(storages, keyStorage)
+		keyCounters[key.Name] = keyCounter
+	}
+	log.Info().Str("dir", conf.StatsDir).Int("keys", len(keyCounters)).Msg("counter loaded from stats directory")
+
+	newKeyCounter := func(name string) stats.Counter {
+		if keyCounter, ok := keyCounters[name]; ok {
+			return keyCounter
+		}
+		return stats.NewFizzbuzzCounter()
+	}
+	return stats.NewKeyedCounter(counter, newKeyCounter), closeStorages, nil
+}
+
+// loadFileCounter creates an in-memory counter persisted in this directory, reloading the counts already there
+func loadFileCounter(dir string) (*stats.FizzbuzzCounter, *stats.FileStorage, error) {
+	storage, errStorage := stats.NewFileStorage(dir)
+	if errStorage != nil {
+		return nil, nil, fmt.Errorf("creating file storage: %w", errStorage)
+	}
+	counter, errLoad := stats.LoadFizzbuzzCounter(storage)
+	if errLoad != nil {
+		storage.Close()
+		return nil, nil, fmt.Errorf("loading counter: %w", errLoad)
+	}
+	return counter, storage, nil
+}
+
+// initRedisCounter creates a counter stored in Redis
+// the counts of each API key are stored under '<prefix>:keys:<name>'
+// an unreachable Redis is only reported so the server does not depend on the start order
+func initRedisCounter(conf config.Conf) (stats.Counter, func() error, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     conf.RedisAddr,
+		Password: conf.RedisPassword,
+		DB:       conf.RedisDB,
+	})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	api.Shutdown(ctx)
+	if errPing := client.Ping(ctx).Err(); errPing != nil {
+		log.Warn().Err(errPing).Str("addr", conf.RedisAddr).Msg("redis unreachable")
+	} else {
+		log.Info().Str("addr", conf.RedisAddr).Msg("connected to redis")
+	}
+
+	closeClient := func() error {
+		if errClose := client.Close(); errClose != nil {
+			return fmt.Errorf("closing redis client: %w", errClose)
+		}
+		return nil
+	}
+	newKeyCounter := func(name string) stats.Counter {
+		return stats.NewRedisCounter(client, conf.RedisPrefix+":keys:"+name)
+	}
+	return stats.NewKeyedCounter(stats.NewRedisCounter(client, conf.RedisPrefix), newKeyCounter), closeClient, nil
 }
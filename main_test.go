@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// client sends each request on its own connection, closed once answered
+// a connection kept open without request would only be closed by the shutdown once its timeout passed
+var client = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+// integration tests sending signals to the built binary
+func Test_Signals(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the binary")
+	}
+	binary := buildBinary(t)
+
+	for name, sig := range map[string]os.Signal{"SIGTERM": syscall.SIGTERM, "SIGINT": syscall.SIGINT} {
+		t.Run(name, func(t *testing.T) {
+			assertions := assert.New(t)
+
+			statsDir := t.TempDir()
+			cmd, addr := startBinary(t, binary, "STATS_DIR="+statsDir, "DRAIN_DELAY=0s", "API_KEYS=client1:k1")
+
+			req, errReq := http.NewRequest("GET", addr+"/fizzbuzz?int1=3&int2=5&limit=16&str1=fizz&str2=buzz", nil)
+			require.NoError(t, errReq)
+			req.Header.Set("X-API-Key", "k1")
+			resp, errGet := client.Do(req)
+			require.NoError(t, errGet)
+			resp.Body.Close()
+			assertions.Equal(http.StatusOK, resp.StatusCode)
+
+			require.NoError(t, cmd.Process.Signal(sig))
+			assertions.NoError(waitExit(t, cmd), "unclean shutdown")
+
+			// the count was flushed in the global snapshot and in the one of the key
+			for _, dir := range []string{statsDir, filepath.Join(statsDir, "keys", "client1")} {
+				snapshot, errRead := os.ReadFile(filepath.Join(dir, "snapshot.jsonl"))
+				assertions.NoError(errRead, dir)
+				assertions.Contains(string(snapshot), `{"count":1,"params":{"int1":3,"int2":5,"limit":16,"str1":"fizz","str2":"buzz"}}`, dir)
+			}
+		})
+	}
+
+	t.Run("shutdown timeout exceeded", func(t *testing.T) {
+		assertions := assert.New(t)
+
+		cmd, addr := startBinary(t, binary, "DRAIN_DELAY=0s", "SHUTDOWN_TIMEOUT=200ms", "MAX_STREAM_LIMIT=0")
+
+		// a streamed response that is never read keeps its request in flight
+		conn, errDial := net.Dial("tcp", strings.TrimPrefix(addr, "http://"))
+		require.NoError(t, errDial)
+		defer conn.Close()
+		_, errWrite := fmt.Fprintf(conn, "GET /fizzbuzz?stream=true&int1=3&int2=5&limit=1000000000 HTTP/1.1\r\nHost: test\r\n\r\n")
+		require.NoError(t, errWrite)
+		statusLine, errStatus := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, errStatus)
+		assertions.Contains(statusLine, "200")
+
+		require.NoError(t, cmd.Process.Signal(syscall.SIGTERM))
+		errExit := waitExit(t, cmd)
+		var exitErr *exec.ExitError
+		if assertions.True(errors.As(errExit, &exitErr), "exited cleanly") {
+			assertions.Equal(1, exitErr.ExitCode())
+		}
+	})
+}
+
+// buildBinary builds the server in a temporary directory
+func buildBinary(t *testing.T) string {
+	binary := filepath.Join(t.TempDir(), "fizzbuzz-server")
+	out, errBuild := exec.Command("go", "build", "-o", binary, ".").CombinedOutput()
+	require.NoError(t, errBuild, string(out))
+	return binary
+}
+
+// startBinary runs the server on a free port with these env vars, and waits for it to be alive
+func startBinary(t *testing.T, binary string, env ...string) (*exec.Cmd, string) {
+	listener, errListen := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, errListen)
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	cmd := exec.Command(binary)
+	cmd.Env = append(os.Environ(), append(env, fmt.Sprintf("PORT=%d", port), "LOG_LEVEL=warn")...)
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() { cmd.Process.Kill() })
+
+	addr := fmt.Sprintf("http://127.0.0.1:%d", port)
+	require.Eventually(t, func() bool {
+		resp, errGet := client.Get(addr + "/healthz")
+		if errGet != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 10*time.Second, 50*time.Millisecond, "server not started")
+	return cmd, addr
+}
+
+// waitExit waits for the server to exit, failing the test if it takes too long
+func waitExit(t *testing.T, cmd *exec.Cmd) error {
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case errWait := <-exited:
+		return errWait
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not exit")
+		return nil
+	}
+}